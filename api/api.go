@@ -1,16 +1,28 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"expvar"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"hub/storage"
 
 	"github.com/google/uuid"
 	"github.com/rs/cors"
 )
 
+// leads is the lead store backing the /leads endpoints.
+var leads *LeadStore
+
 // statusHandler handles requests to the /status endpoint.
 func statusHandler(w http.ResponseWriter, r *http.Request) {
 	status := Status{Status: "online"}
@@ -19,19 +31,120 @@ func statusHandler(w http.ResponseWriter, r *http.Request) {
 
 // leadsHandler handles requests to the /leads endpoint.
 func leadsHandler(w http.ResponseWriter, r *http.Request) {
-	leads := []Lead{
-		{
-			ID:   uuid.New(),
-			Name: "Public Chat",
-			Icon: "📡",
-			Coordinate: Coordinate{
-				Latitude:  33.98686098062241,
-				Longitude: -118.4754199190118,
-			},
-			ChannelID: "92ef3ac79a8772ddf16a2e74e239a67bc95caebdb5bd59191c95cf91685dfc8e",
-		},
-	}
-	sendJSONResponse(w, leads)
+	switch r.Method {
+	case http.MethodGet:
+		query, err := parseLeadQuery(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if query == nil {
+			sendJSONResponse(w, leads.List())
+			return
+		}
+		sendJSONResponse(w, leads.Query(*query))
+
+	case http.MethodPost:
+		var lead Lead
+		if err := json.NewDecoder(r.Body).Decode(&lead); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		lead.Pubkey, _ = PubkeyFromContext(r.Context())
+
+		created, err := leads.Create(lead)
+		if err != nil {
+			sendLeadError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		sendJSONResponse(w, created)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// leadHandler handles requests to the /leads/{id} endpoint.
+func leadHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(strings.TrimPrefix(r.URL.Path, "/leads/"))
+	if err != nil {
+		http.Error(w, "Invalid lead ID", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		lead, err := leads.Get(id)
+		if err != nil {
+			sendLeadError(w, err)
+			return
+		}
+		sendJSONResponse(w, lead)
+
+	case http.MethodPut:
+		var lead Lead
+		if err := json.NewDecoder(r.Body).Decode(&lead); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+
+		existing, err := authorizeLeadChange(r, id)
+		if err != nil {
+			sendLeadError(w, err)
+			return
+		}
+		lead.Pubkey = existing.Pubkey
+
+		updated, err := leads.Update(id, lead)
+		if err != nil {
+			sendLeadError(w, err)
+			return
+		}
+		sendJSONResponse(w, updated)
+
+	case http.MethodDelete:
+		if _, err := authorizeLeadChange(r, id); err != nil {
+			sendLeadError(w, err)
+			return
+		}
+		if err := leads.Delete(id); err != nil {
+			sendLeadError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// authorizeLeadChange returns the lead if the authenticated caller may modify it.
+func authorizeLeadChange(r *http.Request, id uuid.UUID) (Lead, error) {
+	lead, err := leads.Get(id)
+	if err != nil {
+		return Lead{}, err
+	}
+	pubkey, _ := PubkeyFromContext(r.Context())
+	if !canModifyLead(lead, pubkey) {
+		return Lead{}, ErrLeadForbidden
+	}
+	return lead, nil
+}
+
+// sendLeadError maps lead store errors to HTTP responses.
+func sendLeadError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrLeadNotFound):
+		http.Error(w, "Lead not found", http.StatusNotFound)
+	case errors.Is(err, ErrLeadForbidden):
+		http.Error(w, "Forbidden", http.StatusForbidden)
+	case errors.Is(err, errInvalidLead):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		log.Printf("Lead store error: %v\n", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
 }
 
 // sendJSONResponse sends a JSON response with the correct headers.
@@ -43,33 +156,134 @@ func sendJSONResponse(w http.ResponseWriter, data interface{}) {
 	}
 }
 
-// Start initializes and starts the HTTP server.
+// serveDebug serves the expvar counters at /debug/vars on DEBUG_ADDR,
+// 127.0.0.1:6060 by default, until ctx is cancelled. The address should not
+// be reachable from outside.
+func serveDebug(ctx context.Context) {
+	addr := os.Getenv("DEBUG_ADDR")
+	if addr == "" {
+		addr = "127.0.0.1:6060"
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	server := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	log.Printf("Serving debug vars on %s\n", addr)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("⚠️ Debug server stopped: %v\n", err)
+	}
+}
+
+// Start initializes and starts the HTTP server. It returns after SIGINT or
+// SIGTERM once in-flight requests have drained and relay connections are closed.
 func Start() {
-	// Initialize WebSocket broadcasting
-	InitWebSocket()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Load the lead store
+	leadsFile := os.Getenv("LEADS_FILE")
+	if leadsFile == "" {
+		leadsFile = "leads.json"
+	}
+	var err error
+	leads, err = NewLeadStore(leadsFile)
+	if err != nil {
+		log.Fatalf("Failed to load leads: %v\n", err)
+	}
+
+	// Load token signing keys
+	tokenKeys, err = LoadKeySet()
+	if err != nil {
+		log.Fatalf("Failed to load JWT keys: %v\n", err)
+	}
+
+	// Track refresh tokens and revocations
+	sessions = NewTokenSessions()
+	go sessions.Run(ctx)
+
+	// Load per-route rate limits
+	limiter, err = LoadRateLimiter()
+	if err != nil {
+		log.Fatalf("Failed to load rate limits: %v\n", err)
+	}
+	go limiter.Run(ctx)
+
+	// Forget NIP-98 auth events once they can no longer be replayed
+	go sweepAuthEvents(ctx)
+
+	// Share relay connections across requests
+	relays = NewRelayPool()
+	defer relays.Close()
+
+	// Cache channel reads, kept fresh by live subscriptions
+	channelCache = NewChannelCache(relays)
+	defer channelCache.Close()
+
+	// Keep leads in sync with located NIP-28 channels
+	go NewChannelLeadSync(leads, relays).Run(ctx)
+
+	// Open blob storage and index channel media in it
+	blobs, err = storage.New(blobStoreConfig())
+	if err != nil {
+		log.Printf("⚠️ Storage disabled: %v\n", err)
+	} else {
+		mediaIndex = NewMediaIndex(blobs)
+		go mediaIndex.Run(ctx, envDuration("MEDIA_INDEX_INTERVAL", time.Minute))
+	}
+
+	// Keep recent room messages for replay to joining WebSocket clients
+	wsHistory, err = NewHistory()
+	if err != nil {
+		log.Fatalf("Failed to load WebSocket history: %v\n", err)
+	}
+	defer wsHistory.Close()
+
+	// Initialize WebSocket rooms, bridged to their NIP-28 channels
+	if err := InitWebSocket(ctx, relays, wsHistory); err != nil {
+		log.Fatalf("Failed to start WebSocket hub: %v\n", err)
+	}
 
 	// Create a new ServeMux
 	mux := http.NewServeMux()
 
 	// Register handlers
 	mux.HandleFunc("/status", statusHandler)
-	mux.HandleFunc("/leads", leadsHandler)
-	mux.HandleFunc("/keys", handleKeysRequest)
+	mux.HandleFunc("/leads", requireNostrAuthForWrites(rateLimitByPubkey(leadsHandler)))
+	mux.HandleFunc("/leads/", requireNostrAuthForWrites(rateLimitByPubkey(leadHandler)))
 	mux.HandleFunc("/channel/", handleChannelVideos)
-	mux.HandleFunc("/token", handleTokenRequest)
+	mux.HandleFunc("/token", requireNostrAuth(rateLimitByPubkey(handleTokenRequest)))
+	mux.HandleFunc("/token/refresh", handleTokenRefresh)
+	mux.HandleFunc("/token/revoke", requireNostrAuth(rateLimitByPubkey(handleTokenRevoke)))
+	mux.HandleFunc("/.well-known/jwks.json", handleJWKS)
+	mux.HandleFunc("/uploads", requireToken(rateLimitByPubkey(handleUploads)))
+	mux.HandleFunc("/uploads/complete", requireToken(rateLimitByPubkey(handleUploadComplete)))
+	mux.HandleFunc("/uploads/abort", requireToken(rateLimitByPubkey(handleUploadAbort)))
+	if local, ok := blobs.(*storage.LocalStore); ok {
+		mux.Handle("/blobs/", http.StripPrefix("/blobs", local))
+	}
+	mux.HandleFunc("/presence/", handlePresence)
 	mux.HandleFunc("/ws", HandleWebSocket) // WebSocket endpoint
 
+	// Serve metrics on the internal listener only
+	go serveDebug(ctx)
+
 	// Configure CORS
 	c := cors.New(cors.Options{
 		AllowedOrigins:   []string{"https://skatepark.chat", "http://localhost:3000"},
-		AllowedMethods:   []string{"GET", "POST", "OPTIONS"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
 		AllowedHeaders:   []string{"Content-Type", "Authorization"},
 		AllowCredentials: true,
 		Debug:            false,
 	})
 
-	// Wrap the ServeMux with the CORS middleware
-	handler := c.Handler(mux)
+	// Wrap the ServeMux with the CORS middleware, serving Blossom routes
+	// alongside it, and rate limit everything by client IP
+	handler := rateLimitByIP(blossomRoutes(c.Handler(mux)))
 
 	// Get the port from environment variable, default to 8080
 	port := os.Getenv("PORT")
@@ -77,9 +291,29 @@ func Start() {
 		port = "8080"
 	}
 
+	server := &http.Server{
+		Addr:    ":" + port,
+		Handler: handler,
+	}
+
+	// Drain in-flight requests on shutdown
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		<-ctx.Done()
+		log.Println("Shutting down server...")
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Error shutting down server: %v\n", err)
+		}
+	}()
+
 	// Start the server with CORS middleware
 	fmt.Printf("Starting server on port %s\n", port)
-	if err := http.ListenAndServe(":"+port, handler); err != nil {
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Fatalf("Failed to start server: %v\n", err)
 	}
+	<-drained
 }
@@ -1,134 +1,202 @@
 package api
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3"
+	"hub/storage"
 
 	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
 )
 
+// Operations a storage token can grant.
+const (
+	OpRead   = "read"
+	OpUpload = "upload"
+	OpDelete = "delete"
+)
+
+// TokenClaims scope a storage token. Subject is the pubkey the token was
+// issued to; it may only perform Ops on keys under KeyPrefix, and only in
+// Channel when one is set.
 type TokenClaims struct {
-	AllowedBucket string `json:"bucket"`
+	AllowedBucket string   `json:"bucket"`
+	Ops           []string `json:"ops"`
+	KeyPrefix     string   `json:"prefix"`
+	Channel       string   `json:"channel,omitempty"`
+	// IssuedAtNanos is iat in nanoseconds, so a token issued right after a
+	// RevokeAll is not mistaken for one issued before it.
+	IssuedAtNanos int64 `json:"iat_ns,omitempty"`
 	jwt.StandardClaims
 }
 
-var jwtKey = []byte("your-secret-key")
+// issuedAt is when the token was issued, to the second for tokens without
+// IssuedAtNanos.
+func (c *TokenClaims) issuedAt() time.Time {
+	if c.IssuedAtNanos != 0 {
+		return time.Unix(0, c.IssuedAtNanos)
+	}
+	return time.Unix(c.IssuedAt, 0)
+}
+
+// TokenScope is what a caller asks /token for.
+type TokenScope struct {
+	Ops       []string
+	KeyPrefix string
+	Channel   string
+}
+
+// tokenIssuer is the iss claim of issued tokens, from TOKEN_ISSUER.
+func tokenIssuer() string {
+	if iss := os.Getenv("TOKEN_ISSUER"); iss != "" {
+		return iss
+	}
+	return "hub"
+}
+
+// tokenAudience is the aud claim of issued tokens, from TOKEN_AUDIENCE.
+func tokenAudience() string {
+	if aud := os.Getenv("TOKEN_AUDIENCE"); aud != "" {
+		return aud
+	}
+	return "hub-storage"
+}
+
+// accessTokenTTL is how long access tokens are valid, from ACCESS_TOKEN_TTL.
+// Callers renew them through /token/refresh.
+func accessTokenTTL() time.Duration {
+	return envDuration("ACCESS_TOKEN_TTL", 15*time.Minute)
+}
 
-func GenerateToken(bucket string) (string, error) {
-	expirationTime := time.Now().Add(1 * time.Hour)
+// GenerateToken issues a storage token for pubkey limited to scope and
+// returns it with its expiry.
+func GenerateToken(pubkey string, scope TokenScope) (string, time.Time, error) {
+	now := time.Now()
+	expirationTime := now.Add(accessTokenTTL())
 	claims := &TokenClaims{
-		AllowedBucket: bucket,
+		AllowedBucket: storageBucket(),
+		Ops:           scope.Ops,
+		KeyPrefix:     scope.KeyPrefix,
+		Channel:       scope.Channel,
+		IssuedAtNanos: now.UnixNano(),
 		StandardClaims: jwt.StandardClaims{
+			Id:        uuid.New().String(),
+			Subject:   pubkey,
+			Issuer:    tokenIssuer(),
+			Audience:  tokenAudience(),
+			IssuedAt:  now.Unix(),
 			ExpiresAt: expirationTime.Unix(),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtKey)
+	tokenString, err := tokenKeys.Sign(claims)
+	return tokenString, expirationTime, err
 }
 
-func handleTokenRequest(w http.ResponseWriter, r *http.Request) {
-	bucket := r.URL.Query().Get("bucket")
-	if bucket == "" {
-		http.Error(w, "Bucket required", http.StatusBadRequest)
-		return
+// Authorize reports why the token does not permit op on key, or nil if it
+// does. Uploads and deletes are further limited to keys owned by the subject,
+// except for admins.
+func (c *TokenClaims) Authorize(op, key string) error {
+	if !storage.ValidKey(key) {
+		return errors.New("invalid key")
+	}
+	if c.AllowedBucket != storageBucket() {
+		return errors.New("token was issued for another bucket")
 	}
 
-	tokenString, err := GenerateToken(bucket)
-	if err != nil {
-		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
-		return
+	granted := false
+	for _, allowed := range c.Ops {
+		if allowed == op {
+			granted = true
+			break
+		}
+	}
+	if !granted {
+		return fmt.Errorf("token does not grant %s", op)
 	}
 
-	json.NewEncoder(w).Encode(map[string]string{
-		"token": tokenString,
-	})
+	if !strings.HasPrefix(key, c.KeyPrefix) {
+		return fmt.Errorf("key is outside the token prefix %q", c.KeyPrefix)
+	}
+	if c.Channel != "" && channelFromKey(key) != c.Channel {
+		return errors.New("key is outside the token channel")
+	}
+	if op != OpRead && ownerFromKey(key) != c.Subject && !isAdmin(c.Subject) {
+		return errors.New("key belongs to another pubkey")
+	}
+	return nil
 }
 
-func getS3VideosByChannel(channelId string) ([]string, error) {
-	fmt.Print(channelId)
-	sess, err := session.NewSession(&aws.Config{
-		Region: aws.String(os.Getenv("AWS_REGION")),
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to create session: %v", err)
+// handleTokenRequest issues a storage token and refresh token to the NIP-98
+// authenticated caller. The query may narrow the scope with ops
+// (comma-separated, default "read,upload"), channel and prefix; bucket, if
+// given, must be the configured one.
+func handleTokenRequest(w http.ResponseWriter, r *http.Request) {
+	if storageBucket() == "" {
+		http.Error(w, "Storage not configured", http.StatusServiceUnavailable)
+		return
 	}
 
-	svc := s3.New(sess)
-	bucket := os.Getenv("S3_BUCKET")
+	query := r.URL.Query()
+	if bucket := query.Get("bucket"); bucket != "" && bucket != storageBucket() {
+		http.Error(w, fmt.Sprintf("Forbidden: tokens are not issued for bucket %q", bucket), http.StatusForbidden)
+		return
+	}
 
-	// Use S3 ListObjectsV2 API to list all objects with tag channelId=<channelId>
-	input := &s3.ListObjectsV2Input{
-		Bucket: aws.String(bucket),
+	scope, err := parseTokenScope(query.Get("ops"), query.Get("channel"), query.Get("prefix"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	result, err := svc.ListObjectsV2(input)
+	pubkey, _ := PubkeyFromContext(r.Context())
+	pair, err := sessions.Issue(pubkey, scope)
 	if err != nil {
-		return nil, fmt.Errorf("unable to list items in bucket %q, %v", bucket, err)
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
 	}
+	sendJSONResponse(w, pair)
+}
 
-	var videos []string
-	for _, item := range result.Contents {
-		// Check if the item is tagged with the correct channelId
-		tagsInput := &s3.GetObjectTaggingInput{
-			Bucket: aws.String(bucket),
-			Key:    item.Key,
-		}
+// parseTokenScope validates the scope requested from /token.
+func parseTokenScope(ops, channel, prefix string) (TokenScope, error) {
+	var scope TokenScope
 
-		tagsResult, err := svc.GetObjectTagging(tagsInput)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get tags for object %q, %v", *item.Key, err)
+	if ops == "" {
+		ops = OpRead + "," + OpUpload
+	}
+	seen := make(map[string]bool)
+	for _, op := range strings.Split(ops, ",") {
+		op = strings.TrimSpace(op)
+		switch op {
+		case OpRead, OpUpload, OpDelete:
+		default:
+			return scope, fmt.Errorf("unknown op %q", op)
 		}
-
-		for _, tag := range tagsResult.TagSet {
-			if *tag.Key == "channel" && *tag.Value == channelId {
-				// Append the S3 URL for the video
-				videos = append(videos, fmt.Sprintf("https://%s.s3.amazonaws.com/%s", bucket, *item.Key))
-			}
+		if !seen[op] {
+			seen[op] = true
+			scope.Ops = append(scope.Ops, op)
 		}
 	}
 
-	return videos, nil
+	scope.KeyPrefix = "channels/"
+	if channel != "" {
+		if !isValidChannelID(channel) {
+			return scope, errors.New("invalid channel")
+		}
+		scope.Channel = channel
+		scope.KeyPrefix = channelKeyPrefix(channel)
+	}
+	if prefix != "" {
+		if !strings.HasPrefix(prefix, scope.KeyPrefix) {
+			return scope, fmt.Errorf("prefix must start with %q", scope.KeyPrefix)
+		}
+		scope.KeyPrefix = prefix
+	}
+	return scope, nil
 }
-
-// func handleChannelVideos(w http.ResponseWriter, r *http.Request) {
-// 	// Extract channelId from the URL path (e.g., /channel/{channelId})
-// 	path := r.URL.Path
-// 	parts := strings.Split(path, "/")
-// 	if len(parts) < 3 {
-// 		http.Error(w, "Invalid URL path", http.StatusBadRequest)
-// 		return
-// 	}
-// 	channelId := parts[2]
-
-// 	// Get videos from S3 by channelId
-// 	videos, err := getS3VideosByChannel(channelId)
-// 	if err != nil {
-// 		log.Printf("Failed to retrieve videos for channelId=%s: %v", channelId, err)
-// 		http.Error(w, "Failed to retrieve videos", http.StatusInternalServerError)
-// 		return
-// 	}
-
-// 	// Set the Content-Type header to application/json
-// 	w.Header().Set("Content-Type", "application/json")
-
-// 	// Create the response structure
-// 	response := map[string][]string{
-// 		"videos": videos,
-// 	}
-
-// 	// Encode the response as JSON and send it
-// 	err = json.NewEncoder(w).Encode(response)
-// 	if err != nil {
-// 		log.Printf("Failed to encode JSON response: %v", err)
-// 		http.Error(w, "Failed to encode JSON response", http.StatusInternalServerError)
-// 		return
-// 	}
-// }
@@ -0,0 +1,493 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"hub/storage"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// kindBlossomAuth is the Blossom (BUD-01) authorization event kind.
+const kindBlossomAuth = 24242
+
+// Blossom blobs are stored content-addressed, with two empty marker objects
+// per owner so both "what did this pubkey upload" and "who still references
+// this blob" are prefix listings:
+//
+//	blossom/blobs/<sha256>               the blob
+//	blossom/owners/<pubkey>/<sha256>     marker carrying the descriptor
+//	blossom/refs/<sha256>/<pubkey>       marker
+const (
+	blossomBlobPrefix  = "blossom/blobs/"
+	blossomOwnerPrefix = "blossom/owners/"
+	blossomRefPrefix   = "blossom/refs/"
+)
+
+var blossomBlobPath = regexp.MustCompile(`^/([0-9a-f]{64})(\.[A-Za-z0-9]{1,10})?$`)
+
+// imageMimeTypes maps the image MIME types Blossom blobs are commonly
+// uploaded with to a file extension.
+var imageMimeTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// allowedMediaType reports whether blobs of contentType may be stored: the
+// raster image types above and the video types of videoMimeTypes. Anything
+// a browser could run as a document, such as SVG or HTML, is refused.
+func allowedMediaType(contentType string) bool {
+	return extensionForType(contentType) != ""
+}
+
+// setBlobHeaders keeps browsers from sniffing a blob into another type or
+// running it as a document on our origin.
+func setBlobHeaders(w http.ResponseWriter) {
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "sandbox")
+}
+
+// BlobDescriptor describes a stored blob (BUD-02).
+type BlobDescriptor struct {
+	URL      string `json:"url"`
+	SHA256   string `json:"sha256"`
+	Size     int64  `json:"size"`
+	Type     string `json:"type"`
+	Uploaded int64  `json:"uploaded"`
+}
+
+// blossomRoutes sends Blossom requests to the Blossom handler and everything
+// else to next. Blossom clients expect CORS from any origin, so these routes
+// bypass the API's CORS policy.
+func blossomRoutes(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := r.URL.Path
+		if p == "/upload" || strings.HasPrefix(p, "/list/") || blossomBlobPath.MatchString(p) {
+			handleBlossom(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleBlossom implements the BUD-01 and BUD-02 endpoints.
+func handleBlossom(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Access-Control-Allow-Headers", "Authorization, *")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, PUT, DELETE")
+		w.Header().Set("Access-Control-Max-Age", "86400")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if blobs == nil {
+		blossomError(w, http.StatusServiceUnavailable, "Storage not configured")
+		return
+	}
+
+	switch {
+	case r.URL.Path == "/upload":
+		if r.Method != http.MethodPut {
+			blossomError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		handleBlossomUpload(w, r)
+
+	case strings.HasPrefix(r.URL.Path, "/list/"):
+		if r.Method != http.MethodGet {
+			blossomError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		handleBlossomList(w, r, strings.TrimPrefix(r.URL.Path, "/list/"))
+
+	default:
+		sha := blossomBlobPath.FindStringSubmatch(r.URL.Path)[1]
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			handleBlossomGet(w, r, sha)
+		case http.MethodDelete:
+			handleBlossomDelete(w, r, sha)
+		default:
+			blossomError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		}
+	}
+}
+
+// handleBlossomUpload stores the request body under its SHA-256 and records
+// the uploader as an owner.
+func handleBlossomUpload(w http.ResponseWriter, r *http.Request) {
+	auth, err := verifyBlossomAuth(r, "upload")
+	if err != nil {
+		blossomError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	if !allowRequest(w, r, "pubkey", "pubkey:"+auth.PubKey) {
+		return
+	}
+
+	tmp, err := os.CreateTemp("", "blossom-*")
+	if err != nil {
+		log.Printf("Failed to create temp file for upload: %v", err)
+		blossomError(w, http.StatusInternalServerError, "Failed to store blob")
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	maxSize := int64(envInt("BLOSSOM_MAX_BYTES", 500<<20))
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), http.MaxBytesReader(w, r.Body, maxSize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			blossomError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Blob exceeds %d bytes", maxSize))
+			return
+		}
+		blossomError(w, http.StatusBadRequest, "Failed to read request body")
+		return
+	}
+	if size == 0 {
+		blossomError(w, http.StatusBadRequest, "Empty body")
+		return
+	}
+	sha := hex.EncodeToString(hash.Sum(nil))
+
+	if !authCoversBlob(auth, sha) {
+		blossomError(w, http.StatusForbidden, "Authorization x tag does not match the blob hash")
+		return
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		blossomError(w, http.StatusInternalServerError, "Failed to store blob")
+		return
+	}
+	contentType := blobContentType(r.Header.Get("Content-Type"), tmp)
+	if !allowedMediaType(contentType) {
+		blossomError(w, http.StatusUnsupportedMediaType, "Only video and raster image blobs are accepted")
+		return
+	}
+
+	ctx := r.Context()
+	if _, err := blobs.Head(ctx, blossomBlobPrefix+sha); errors.Is(err, storage.ErrNotFound) {
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			blossomError(w, http.StatusInternalServerError, "Failed to store blob")
+			return
+		}
+		if err := blobs.Put(ctx, blossomBlobPrefix+sha, tmp, storage.PutOptions{ContentType: contentType}); err != nil {
+			log.Printf("Failed to store blob %s: %v", sha, err)
+			blossomError(w, http.StatusInternalServerError, "Failed to store blob")
+			return
+		}
+	} else if err != nil {
+		log.Printf("Failed to look up blob %s: %v", sha, err)
+		blossomError(w, http.StatusInternalServerError, "Failed to store blob")
+		return
+	}
+
+	descriptor, err := addBlobOwner(r, auth.PubKey, BlobDescriptor{
+		URL:      blobURL(r, sha, contentType),
+		SHA256:   sha,
+		Size:     size,
+		Type:     contentType,
+		Uploaded: time.Now().Unix(),
+	})
+	if err != nil {
+		log.Printf("Failed to record owner of blob %s: %v", sha, err)
+		blossomError(w, http.StatusInternalServerError, "Failed to store blob")
+		return
+	}
+
+	log.Printf("Stored blob %s (%d bytes) for %s", sha, size, auth.PubKey)
+	sendJSONResponse(w, descriptor)
+}
+
+// handleBlossomGet serves a blob, or only its headers for HEAD.
+func handleBlossomGet(w http.ResponseWriter, r *http.Request, sha string) {
+	info, err := blobs.Head(r.Context(), blossomBlobPrefix+sha)
+	if errors.Is(err, storage.ErrNotFound) {
+		blossomError(w, http.StatusNotFound, "Blob not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to look up blob %s: %v", sha, err)
+		blossomError(w, http.StatusInternalServerError, "Failed to read blob")
+		return
+	}
+
+	w.Header().Set("Content-Type", info.ContentType)
+	setBlobHeaders(w)
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("ETag", `"`+sha+`"`)
+	if r.Method == http.MethodHead {
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	body, info, err := blobs.Get(r.Context(), blossomBlobPrefix+sha)
+	if err != nil {
+		log.Printf("Failed to read blob %s: %v", sha, err)
+		blossomError(w, http.StatusInternalServerError, "Failed to read blob")
+		return
+	}
+	defer body.Close()
+
+	if seeker, ok := body.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, "", info.LastModified, seeker)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	io.Copy(w, body)
+}
+
+// handleBlossomDelete removes the caller's ownership of a blob, and the blob
+// itself once nobody owns it.
+func handleBlossomDelete(w http.ResponseWriter, r *http.Request, sha string) {
+	auth, err := verifyBlossomAuth(r, "delete")
+	if err != nil {
+		blossomError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	if !allowRequest(w, r, "pubkey", "pubkey:"+auth.PubKey) {
+		return
+	}
+	if !authCoversBlob(auth, sha) {
+		blossomError(w, http.StatusForbidden, "Authorization x tag does not match the blob hash")
+		return
+	}
+
+	ctx := r.Context()
+	ownerKey := blossomOwnerPrefix + auth.PubKey + "/" + sha
+	if _, err := blobs.Head(ctx, ownerKey); errors.Is(err, storage.ErrNotFound) {
+		blossomError(w, http.StatusNotFound, "Blob not found")
+		return
+	} else if err != nil {
+		log.Printf("Failed to look up owner of blob %s: %v", sha, err)
+		blossomError(w, http.StatusInternalServerError, "Failed to delete blob")
+		return
+	}
+
+	for _, key := range []string{ownerKey, blossomRefPrefix + sha + "/" + auth.PubKey} {
+		if err := blobs.Delete(ctx, key); err != nil {
+			log.Printf("Failed to delete %s: %v", key, err)
+			blossomError(w, http.StatusInternalServerError, "Failed to delete blob")
+			return
+		}
+	}
+
+	referenced := false
+	err = blobs.List(ctx, blossomRefPrefix+sha+"/", func(storage.ObjectInfo) bool {
+		referenced = true
+		return false
+	})
+	if err == nil && !referenced {
+		err = blobs.Delete(ctx, blossomBlobPrefix+sha)
+	}
+	if err != nil {
+		log.Printf("Failed to delete blob %s: %v", sha, err)
+		blossomError(w, http.StatusInternalServerError, "Failed to delete blob")
+		return
+	}
+
+	log.Printf("Deleted blob %s for %s", sha, auth.PubKey)
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleBlossomList lists the blobs uploaded by pubkey, newest first,
+// optionally limited to the since/until unix timestamps.
+func handleBlossomList(w http.ResponseWriter, r *http.Request, pubkey string) {
+	if !isValidChannelID(pubkey) {
+		blossomError(w, http.StatusBadRequest, "Invalid pubkey")
+		return
+	}
+
+	query := r.URL.Query()
+	var since, until int64
+	for name, dst := range map[string]*int64{"since": &since, "until": &until} {
+		if v := query.Get(name); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				blossomError(w, http.StatusBadRequest, "Invalid "+name)
+				return
+			}
+			*dst = n
+		}
+	}
+
+	ctx := r.Context()
+	var keys []string
+	err := blobs.List(ctx, blossomOwnerPrefix+pubkey+"/", func(info storage.ObjectInfo) bool {
+		keys = append(keys, info.Key)
+		return true
+	})
+	if err != nil {
+		log.Printf("Failed to list blobs for %s: %v", pubkey, err)
+		blossomError(w, http.StatusInternalServerError, "Failed to list blobs")
+		return
+	}
+
+	descriptors := []BlobDescriptor{}
+	for _, key := range keys {
+		info, err := blobs.Head(ctx, key)
+		if err != nil {
+			continue
+		}
+		descriptor := descriptorFromMarker(r, path.Base(key), info.Metadata)
+		if (since > 0 && descriptor.Uploaded < since) || (until > 0 && descriptor.Uploaded > until) {
+			continue
+		}
+		descriptors = append(descriptors, descriptor)
+	}
+	sort.Slice(descriptors, func(i, j int) bool {
+		return descriptors[i].Uploaded > descriptors[j].Uploaded
+	})
+	sendJSONResponse(w, descriptors)
+}
+
+// verifyBlossomAuth checks a kind 24242 authorization event for verb and
+// returns it.
+func verifyBlossomAuth(r *http.Request, verb string) (*nostr.Event, error) {
+	encoded, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Nostr ")
+	if !ok || encoded == "" {
+		return nil, errors.New("missing Nostr authorization")
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, errors.New("authorization is not valid base64")
+	}
+
+	var ev nostr.Event
+	if err := json.Unmarshal(raw, &ev); err != nil {
+		return nil, errors.New("authorization is not a nostr event")
+	}
+	if ev.Kind != kindBlossomAuth {
+		return nil, fmt.Errorf("auth event must be kind %d", kindBlossomAuth)
+	}
+	if err := verifyEvent(&ev); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if ev.CreatedAt.Time().After(now.Add(nip98MaxSkew)) {
+		return nil, errors.New("auth event is in the future")
+	}
+	expiration, err := strconv.ParseInt(tagValue(ev.Tags, "expiration"), 10, 64)
+	if err != nil {
+		return nil, errors.New("auth event has no expiration")
+	}
+	if now.Unix() > expiration {
+		return nil, errors.New("auth event has expired")
+	}
+	if tagValue(ev.Tags, "t") != verb {
+		return nil, fmt.Errorf("auth event t tag must be %q", verb)
+	}
+	return &ev, nil
+}
+
+// authCoversBlob reports whether an auth event has an x tag for sha.
+func authCoversBlob(ev *nostr.Event, sha string) bool {
+	for _, tag := range ev.Tags {
+		if len(tag) >= 2 && tag[0] == "x" && strings.EqualFold(tag[1], sha) {
+			return true
+		}
+	}
+	return false
+}
+
+// addBlobOwner writes the owner and reference markers for a blob and returns
+// the owner's descriptor. Uploading the same blob again keeps the original
+// upload time.
+func addBlobOwner(r *http.Request, pubkey string, descriptor BlobDescriptor) (BlobDescriptor, error) {
+	ctx := r.Context()
+	ownerKey := blossomOwnerPrefix + pubkey + "/" + descriptor.SHA256
+	if info, err := blobs.Head(ctx, ownerKey); err == nil {
+		descriptor = descriptorFromMarker(r, descriptor.SHA256, info.Metadata)
+	} else if errors.Is(err, storage.ErrNotFound) {
+		err := blobs.Put(ctx, ownerKey, strings.NewReader(""), storage.PutOptions{
+			Metadata: map[string]string{
+				"size":     strconv.FormatInt(descriptor.Size, 10),
+				"type":     descriptor.Type,
+				"uploaded": strconv.FormatInt(descriptor.Uploaded, 10),
+			},
+		})
+		if err != nil {
+			return descriptor, err
+		}
+	} else {
+		return descriptor, err
+	}
+
+	err := blobs.Put(ctx, blossomRefPrefix+descriptor.SHA256+"/"+pubkey, strings.NewReader(""), storage.PutOptions{})
+	return descriptor, err
+}
+
+// descriptorFromMarker rebuilds a descriptor from an owner marker's metadata.
+func descriptorFromMarker(r *http.Request, sha string, metadata map[string]string) BlobDescriptor {
+	size, _ := strconv.ParseInt(metadata["size"], 10, 64)
+	uploaded, _ := strconv.ParseInt(metadata["uploaded"], 10, 64)
+	return BlobDescriptor{
+		URL:      blobURL(r, sha, metadata["type"]),
+		SHA256:   sha,
+		Size:     size,
+		Type:     metadata["type"],
+		Uploaded: uploaded,
+	}
+}
+
+// blobURL is the public URL of a blob, with an extension for its type.
+func blobURL(r *http.Request, sha, contentType string) string {
+	return publicBaseURL(r) + "/" + sha + extensionForType(contentType)
+}
+
+// extensionForType returns a file extension for a MIME type, or "".
+func extensionForType(contentType string) string {
+	if ext, ok := imageMimeTypes[contentType]; ok {
+		return ext
+	}
+	for ext, mimeType := range videoMimeTypes {
+		if mimeType == contentType {
+			return ext
+		}
+	}
+	return ""
+}
+
+// blobContentType returns the declared content type, or one sniffed from the
+// start of the body when none was sent.
+func blobContentType(declared string, body io.Reader) string {
+	if mediaType, _, ok := strings.Cut(declared, ";"); ok {
+		declared = mediaType
+	}
+	declared = strings.ToLower(strings.TrimSpace(declared))
+	if declared != "" && declared != "application/octet-stream" {
+		return declared
+	}
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(body, head)
+	return strings.Split(http.DetectContentType(head[:n]), ";")[0]
+}
+
+// blossomError writes an error response with the reason in X-Reason, as
+// Blossom clients expect.
+func blossomError(w http.ResponseWriter, status int, reason string) {
+	w.Header().Set("X-Reason", reason)
+	http.Error(w, reason, status)
+}
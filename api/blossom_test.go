@@ -0,0 +1,55 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"hub/storage"
+)
+
+func TestAllowedMediaType(t *testing.T) {
+	for contentType, want := range map[string]bool{
+		"video/mp4":     true,
+		"video/webm":    true,
+		"image/png":     true,
+		"image/webp":    true,
+		"image/svg+xml": false,
+		"text/html":     false,
+		"video/x-evil":  false,
+		"":              false,
+	} {
+		if got := allowedMediaType(contentType); got != want {
+			t.Errorf("allowedMediaType(%q) = %v, want %v", contentType, got, want)
+		}
+	}
+}
+
+func TestBlossomGetIsSandboxed(t *testing.T) {
+	local, err := storage.NewLocal(storage.Config{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := blobs
+	blobs = local
+	defer func() { blobs = previous }()
+
+	sha := strings.Repeat("a", 64)
+	err = local.Put(context.Background(), blossomBlobPrefix+sha, strings.NewReader("png"), storage.PutOptions{ContentType: "image/png"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, method := range []string{http.MethodGet, http.MethodHead} {
+		w := httptest.NewRecorder()
+		handleBlossomGet(w, httptest.NewRequest(method, "/"+sha, nil), sha)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s = %d, want 200", method, w.Code)
+		}
+		if w.Header().Get("X-Content-Type-Options") != "nosniff" || w.Header().Get("Content-Security-Policy") != "sandbox" {
+			t.Errorf("%s is served without nosniff and sandbox: %v", method, w.Header())
+		}
+	}
+}
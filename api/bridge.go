@@ -0,0 +1,397 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// bridgeSeenSize is how many recent event IDs the bridge remembers to drop
+// duplicates arriving from several relays or echoed back after publishing.
+const bridgeSeenSize = 4096
+
+var (
+	// bridgeEvents counts bridged events by direction: "in" from relays,
+	// "out" to relays, "duplicate" dropped.
+	bridgeEvents = expvar.NewMap("ws_bridge_events")
+	// bridge connects channel rooms on /ws to their NIP-28 channels.
+	bridge *ChannelBridge
+)
+
+func init() {
+	HandleWS(msgEvent, handleEventFrame)
+}
+
+// ChannelBridge relays kind-42 messages between the relays and the /ws rooms
+// of NIP-28 channels. Open channel rooms are followed with one subscription
+// per relay covering all of them; messages from the relays go to the room as
+// "event" frames. A room first joined by an anonymous client is only
+// followed once its kind-40 creation event is found on the relays, so
+// anonymous clients cannot make the hub subscribe to arbitrary IDs.
+//
+// With BRIDGE_PUBLISH set, authenticated clients may publish to a channel
+// room's relays: an "event" frame's kind-42 event signed with their own key,
+// or, when HUB_BRIDGE_KEY holds a secret key, the text of a "message" frame
+// signed by the hub and tagged with the sender's pubkey.
+type ChannelBridge struct {
+	ctx       context.Context
+	pool      *RelayPool
+	hub       *Hub
+	publish   bool
+	secretKey string
+	pubkey    string
+	refresh   []chan struct{} // one per relay, signalled when the followed rooms change
+
+	mu      sync.Mutex
+	rooms   map[string]nostr.Timestamp // followed rooms, with when they were opened
+	pending map[string]nostr.Timestamp // rooms waiting for their creation event
+	seen    *seenSet
+}
+
+// NewChannelBridge creates a bridge for hub's channel rooms and hooks it into
+// the hub; call it before the hub runs. Subscriptions end with ctx.
+func NewChannelBridge(ctx context.Context, pool *RelayPool, hub *Hub) *ChannelBridge {
+	b := &ChannelBridge{
+		ctx:     ctx,
+		pool:    pool,
+		hub:     hub,
+		publish: os.Getenv("BRIDGE_PUBLISH") != "",
+		rooms:   make(map[string]nostr.Timestamp),
+		pending: make(map[string]nostr.Timestamp),
+		seen:    newSeenSet(bridgeSeenSize),
+	}
+	if sk := os.Getenv("HUB_BRIDGE_KEY"); sk != "" {
+		pubkey, err := nostr.GetPublicKey(sk)
+		if err != nil {
+			log.Printf("⚠️ Invalid HUB_BRIDGE_KEY, not signing bridged messages: %v", err)
+		} else {
+			b.secretKey = sk
+			b.pubkey = pubkey
+		}
+	}
+	for _, url := range relayURLs() {
+		refresh := make(chan struct{}, 1)
+		b.refresh = append(b.refresh, refresh)
+		go b.follow(url, refresh)
+	}
+
+	hub.RoomOpened = b.open
+	hub.RoomClosed = b.close
+	return b
+}
+
+// open starts following a channel room opened by client: at once for an
+// authenticated client, otherwise once the channel is known to exist.
+func (b *ChannelBridge) open(client *Client, room string) {
+	if !isValidChannelID(room) {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if client.pubkey != "" {
+		delete(b.pending, room)
+		b.addLocked(room, nostr.Now())
+		return
+	}
+	if _, ok := b.rooms[room]; ok {
+		return
+	}
+	if _, ok := b.pending[room]; ok {
+		return
+	}
+	b.pending[room] = nostr.Now()
+	go b.verifyRoom(room)
+}
+
+// verifyRoom follows a pending room if the relays hold its creation event,
+// unless the room was closed or followed in the meantime.
+func (b *ChannelBridge) verifyRoom(room string) {
+	fetched, _ := FetchEvents(b.ctx, nostr.Filter{
+		IDs:   []string{room},
+		Kinds: []int{nostr.KindChannelCreation},
+	})
+	found := false
+	for i := range fetched.Events {
+		if err := verifyChannelCreation(&fetched.Events[i], room); err != nil {
+			countRejection(err)
+			continue
+		}
+		found = true
+		break
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	opened, ok := b.pending[room]
+	if !ok {
+		return
+	}
+	delete(b.pending, room)
+	if found {
+		b.addLocked(room, opened)
+	}
+}
+
+func (b *ChannelBridge) addLocked(room string, opened nostr.Timestamp) {
+	if _, ok := b.rooms[room]; ok {
+		return
+	}
+	b.rooms[room] = opened
+	b.roomsChanged()
+}
+
+// close stops following a room once its last member has left.
+func (b *ChannelBridge) close(room string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.pending, room)
+	if _, ok := b.rooms[room]; ok {
+		delete(b.rooms, room)
+		b.roomsChanged()
+	}
+}
+
+// roomsChanged tells every relay's follower to resubscribe.
+func (b *ChannelBridge) roomsChanged() {
+	for _, refresh := range b.refresh {
+		select {
+		case refresh <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// follow keeps one subscription on a relay to new messages in every followed
+// room until ctx is cancelled, resubscribing when the rooms change and, after
+// relayKeepAlivePeriod, when the subscription drops.
+func (b *ChannelBridge) follow(url string, refresh <-chan struct{}) {
+	since := nostr.Now()
+	subscribed := make(map[string]bool)
+	filters := func() nostr.Filters {
+		// Rooms new to the subscription need their messages since they
+		// were opened.
+		rooms, filterSince := b.followed(subscribed, since)
+		subscribed = make(map[string]bool, len(rooms))
+		for _, room := range rooms {
+			subscribed[room] = true
+		}
+		// The next subscription picks up from this one's start; the seen
+		// set drops the messages both see.
+		since = nostr.Now()
+		if len(rooms) == 0 {
+			return nil
+		}
+		return nostr.Filters{{
+			Kinds: []int{nostr.KindChannelMessage},
+			Tags:  nostr.TagMap{"e": rooms},
+			Since: &filterSince,
+		}}
+	}
+	b.pool.Follow(b.ctx, url, filters, refresh, b.deliver)
+}
+
+// followed returns the rooms to subscribe to and the since to use: the given
+// one, or the open time of a room missing from subscribed.
+func (b *ChannelBridge) followed(subscribed map[string]bool, since nostr.Timestamp) ([]string, nostr.Timestamp) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	rooms := make([]string, 0, len(b.rooms))
+	for room, opened := range b.rooms {
+		rooms = append(rooms, room)
+		if !subscribed[room] && opened < since {
+			since = opened
+		}
+	}
+	sort.Strings(rooms)
+	return rooms, since
+}
+
+// deliver sends a message from a relay to the followed room it belongs to.
+func (b *ChannelBridge) deliver(ev *nostr.Event) {
+	room, ok := b.roomOf(ev)
+	if !ok {
+		return
+	}
+	if err := verifyChannelEvent(ev, room); err != nil {
+		countRejection(err)
+		return
+	}
+	if !b.seen.Add(ev.ID) {
+		bridgeEvents.Add("duplicate", 1)
+		return
+	}
+	bridgeEvents.Add("in", 1)
+	b.broadcast(room, ev)
+}
+
+// roomOf returns the followed room an event's "e" tags point at.
+func (b *ChannelBridge) roomOf(ev *nostr.Event) (string, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, tag := range ev.Tags {
+		if len(tag) < 2 || tag[0] != "e" {
+			continue
+		}
+		if _, ok := b.rooms[tag[1]]; ok {
+			return tag[1], true
+		}
+	}
+	return "", false
+}
+
+// publishes reports whether messages sent to room go to the relays.
+func (b *ChannelBridge) publishes(room string) bool {
+	return b != nil && b.publish && isValidChannelID(room)
+}
+
+// sign returns the kind-42 event to publish for a text message from pubkey,
+// or nil if the message stays on /ws because the room is not bridged, the
+// sender is anonymous or the hub has no key.
+func (b *ChannelBridge) sign(pubkey, room, text string) (*nostr.Event, error) {
+	if !b.publishes(room) || pubkey == "" || b.secretKey == "" {
+		return nil, nil
+	}
+	return b.hubMessage(room, text, nostr.Tag{"p", pubkey})
+}
+
+// Announce publishes text to a channel as the hub. It does nothing without
+// HUB_BRIDGE_KEY.
+func (b *ChannelBridge) Announce(room, text string) error {
+	if b == nil || b.secretKey == "" || !isValidChannelID(room) {
+		return nil
+	}
+	ev, err := b.hubMessage(room, text)
+	if err != nil {
+		return err
+	}
+	b.Publish(room, ev)
+	return nil
+}
+
+// hubMessage builds a kind-42 message in channel room signed by the hub key.
+func (b *ChannelBridge) hubMessage(room, text string, tags ...nostr.Tag) (*nostr.Event, error) {
+	ev := &nostr.Event{
+		PubKey:    b.pubkey,
+		CreatedAt: nostr.Now(),
+		Kind:      nostr.KindChannelMessage,
+		Tags:      append(nostr.Tags{{"e", room, "", "root"}}, tags...),
+		Content:   text,
+	}
+	if err := ev.Sign(b.secretKey); err != nil {
+		return nil, err
+	}
+	return ev, nil
+}
+
+// handleEventFrame publishes a kind-42 event signed by the authenticated
+// client, the payload of an "event" frame, to a channel room it has joined.
+func handleEventFrame(c *Client, env Envelope) (interface{}, error) {
+	if err := c.allowFrame(); err != nil {
+		return nil, err
+	}
+	if c.pubkey == "" {
+		return nil, &WSError{Code: "unauthorized", Message: "connect with an access token to publish events"}
+	}
+	room, err := frameRoom(env)
+	if err != nil {
+		return nil, err
+	}
+	if !bridge.publishes(room) {
+		return nil, &WSError{Code: "forbidden", Message: "publishing to this room is disabled"}
+	}
+	var ev nostr.Event
+	if err := json.Unmarshal(env.Payload, &ev); err != nil {
+		return nil, &WSError{Code: "invalid_payload", Message: "payload must be a nostr event"}
+	}
+	if ev.PubKey != c.pubkey {
+		return nil, &WSError{Code: "forbidden", Message: "event must be signed by your pubkey"}
+	}
+	if err := verifyChannelEvent(&ev, room); err != nil {
+		return nil, &WSError{Code: "invalid_event", Message: err.Error()}
+	}
+	if !c.hub.IsMember(c, room) {
+		return nil, errNotMember
+	}
+	bridge.Publish(room, &ev)
+	return nil, nil
+}
+
+// Publish sends ev to room's members and to every relay. Events already seen
+// are dropped, and remembering ev keeps its echo from the relays from being
+// delivered twice.
+func (b *ChannelBridge) Publish(room string, ev *nostr.Event) {
+	if !b.seen.Add(ev.ID) {
+		bridgeEvents.Add("duplicate", 1)
+		return
+	}
+	b.broadcast(room, ev)
+
+	timeout := envDuration("RELAY_TIMEOUT", 5*time.Second)
+	for _, url := range relayURLs() {
+		go func(url string) {
+			ctx, cancel := context.WithTimeout(b.ctx, timeout)
+			defer cancel()
+
+			relay, err := b.pool.Relay(ctx, url)
+			if err == nil {
+				err = relay.Publish(ctx, *ev)
+			}
+			if err != nil {
+				log.Printf("Bridge failed to publish %s to %s: %v", ev.ID, url, err)
+				return
+			}
+			bridgeEvents.Add("out", 1)
+		}(url)
+	}
+}
+
+// broadcast sends ev to room's members on this instance as an "event" frame.
+// Other instances get it from the relays themselves.
+func (b *ChannelBridge) broadcast(room string, ev *nostr.Event) {
+	data, err := newFrame(msgEvent, ev.ID, room, ev)
+	if err != nil {
+		log.Println("Error marshalling JSON:", err)
+		return
+	}
+	b.hub.BroadcastLocal(room, data)
+}
+
+// seenSet remembers the last size IDs added to it.
+type seenSet struct {
+	mu    sync.Mutex
+	ids   map[string]struct{}
+	order []string
+	next  int
+}
+
+func newSeenSet(size int) *seenSet {
+	return &seenSet{
+		ids:   make(map[string]struct{}, size),
+		order: make([]string, size),
+	}
+}
+
+// Add records id, reporting false if it was already present.
+func (s *seenSet) Add(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.ids[id]; ok {
+		return false
+	}
+	if old := s.order[s.next]; old != "" {
+		delete(s.ids, old)
+	}
+	s.order[s.next] = id
+	s.next = (s.next + 1) % len(s.order)
+	s.ids[id] = struct{}{}
+	return true
+}
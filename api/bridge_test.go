@@ -0,0 +1,146 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// newTestBridge returns a bridge over one relay whose followers have already
+// stopped, so tests can drive its room set directly.
+func newTestBridge(t *testing.T) *ChannelBridge {
+	t.Helper()
+	t.Setenv("HUB_RELAYS", "wss://a.test")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return NewChannelBridge(ctx, nil, NewHub(nil))
+}
+
+// refreshed reports whether the bridge asked its follower to resubscribe.
+func refreshed(b *ChannelBridge) bool {
+	select {
+	case <-b.refresh[0]:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestBridgeFollowsRoomsOfAuthenticatedClients(t *testing.T) {
+	b := newTestBridge(t)
+	room := strings.Repeat("a", 64)
+	other := strings.Repeat("b", 64)
+	client := &Client{pubkey: strings.Repeat("c", 64)}
+
+	b.open(client, "not-a-channel")
+	b.open(client, room)
+	b.open(client, other)
+	if !refreshed(b) {
+		t.Error("opening a room did not refresh the subscription")
+	}
+	rooms, _ := b.followed(nil, nostr.Now())
+	if strings.Join(rooms, ",") != room+","+other {
+		t.Errorf("followed = %v, want both channel rooms", rooms)
+	}
+
+	// One subscription covers every room
+	ev := &nostr.Event{Tags: nostr.Tags{{"e", other, "", "root"}}}
+	if got, ok := b.roomOf(ev); !ok || got != other {
+		t.Errorf("roomOf = %q, %v, want %s", got, ok, other)
+	}
+
+	b.close(room)
+	if !refreshed(b) {
+		t.Error("closing a room did not refresh the subscription")
+	}
+	if rooms, _ := b.followed(nil, nostr.Now()); len(rooms) != 1 || rooms[0] != other {
+		t.Errorf("followed after close = %v, want only %s", rooms, other)
+	}
+	if _, ok := b.roomOf(&nostr.Event{Tags: nostr.Tags{{"e", room}}}); ok {
+		t.Error("roomOf matched a closed room")
+	}
+}
+
+func TestBridgeNeedsChannelCreationForAnonymousRooms(t *testing.T) {
+	b := newTestBridge(t)
+	room := strings.Repeat("a", 64)
+	stubRelays(t, map[string]func() ([]nostr.Event, error){
+		// Not signed, so it does not prove the channel exists
+		"wss://a.test": func() ([]nostr.Event, error) {
+			return []nostr.Event{{ID: room, Kind: nostr.KindChannelCreation}}, nil
+		},
+	})
+
+	b.mu.Lock()
+	b.pending[room] = nostr.Now()
+	b.mu.Unlock()
+	b.verifyRoom(room)
+	if rooms, _ := b.followed(nil, nostr.Now()); len(rooms) != 0 {
+		t.Errorf("followed = %v, want no room without a valid kind 40", rooms)
+	}
+	if refreshed(b) {
+		t.Error("an unverified room refreshed the subscription")
+	}
+
+	// An authenticated client opens it without the check
+	b.mu.Lock()
+	b.pending[room] = nostr.Now()
+	b.mu.Unlock()
+	b.open(&Client{pubkey: strings.Repeat("c", 64)}, room)
+	b.verifyRoom(room)
+	if rooms, _ := b.followed(nil, nostr.Now()); len(rooms) != 1 {
+		t.Errorf("followed = %v, want the room opened by an authenticated client", rooms)
+	}
+}
+
+func TestHandleEventFrameChecksSender(t *testing.T) {
+	t.Setenv("WS_FRAME_RATE", "60")
+	t.Setenv("WS_FRAME_BURST", "3")
+	t.Setenv("BRIDGE_PUBLISH", "1")
+	previous := bridge
+	bridge = newTestBridge(t)
+	defer func() { bridge = previous }()
+
+	room := strings.Repeat("a", 64)
+	pubkey := strings.Repeat("c", 64)
+	frame := func(author string) Envelope {
+		payload, _ := json.Marshal(nostr.Event{
+			PubKey: author,
+			Kind:   nostr.KindChannelMessage,
+			Tags:   nostr.Tags{{"e", room, "", "root"}},
+		})
+		return Envelope{Type: msgEvent, Room: room, Payload: payload}
+	}
+	code := func(err error) string {
+		var wsErr *WSError
+		if !errors.As(err, &wsErr) {
+			return ""
+		}
+		return wsErr.Code
+	}
+
+	anonymous := &Client{hub: NewHub(nil)}
+	if _, err := handleEventFrame(anonymous, frame(pubkey)); code(err) != "unauthorized" {
+		t.Errorf("event from an anonymous client = %v, want unauthorized", err)
+	}
+
+	client := &Client{hub: NewHub(nil), pubkey: pubkey}
+	if _, err := handleEventFrame(client, frame(strings.Repeat("d", 64))); code(err) != "forbidden" {
+		t.Errorf("event signed by another pubkey = %v, want forbidden", err)
+	}
+
+	// Rejected frames still spend the budget
+	if _, err := handleEventFrame(client, Envelope{Type: msgEvent, Room: "bad"}); code(err) != "invalid_room" {
+		t.Errorf("event to an invalid room = %v, want invalid_room", err)
+	}
+	if _, err := handleEventFrame(client, frame(pubkey)); code(err) == "rate_limited" {
+		t.Errorf("frame within the burst = %v", err)
+	}
+	if _, err := handleEventFrame(client, frame(pubkey)); code(err) != "rate_limited" {
+		t.Errorf("frame past the burst = %v, want rate_limited", err)
+	}
+}
@@ -0,0 +1,383 @@
+package api
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const cacheSeedTimeout = 15 * time.Second
+
+// channelCache serves repeat /channel/{id} reads from memory.
+var channelCache *ChannelCache
+
+// cacheEntry holds the newest messages of one channel, newest first.
+type cacheEntry struct {
+	channelID  string
+	events     []nostr.Event
+	ids        map[string]bool
+	bytes      int
+	complete   bool // the entry holds the channel's entire history
+	lastAccess time.Time
+	following  bool            // the entry is kept fresh by the live subscriptions
+	seededAt   nostr.Timestamp // when the seed fetch started
+	ready      chan struct{}   // closed once the seed fetch finished
+	err        error
+}
+
+// ChannelCache keeps recent kind 42 messages per channel in memory. Entries
+// are seeded with FetchEvents and then kept fresh by one live subscription
+// per relay covering every cached channel, so the cache holds a single
+// subscription slot on each relay however many channels it follows. The
+// cache is bounded by channel count, events per channel, total size and an
+// idle TTL.
+type ChannelCache struct {
+	pool        *RelayPool
+	ctx         context.Context
+	cancel      context.CancelFunc
+	refresh     []chan struct{} // one per relay, signalled when the followed set changes
+	mu          sync.Mutex
+	entries     map[string]*cacheEntry
+	bytes       int
+	maxChannels int
+	maxEvents   int
+	maxBytes    int
+	ttl         time.Duration
+}
+
+// NewChannelCache creates a cache configured from CHANNEL_CACHE_CHANNELS,
+// CHANNEL_CACHE_EVENTS, CHANNEL_CACHE_BYTES and CHANNEL_CACHE_TTL.
+func NewChannelCache(pool *RelayPool) *ChannelCache {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &ChannelCache{
+		pool:        pool,
+		ctx:         ctx,
+		cancel:      cancel,
+		entries:     make(map[string]*cacheEntry),
+		maxChannels: envInt("CHANNEL_CACHE_CHANNELS", 256),
+		maxEvents:   envInt("CHANNEL_CACHE_EVENTS", 500),
+		maxBytes:    envInt("CHANNEL_CACHE_BYTES", 64<<20),
+		ttl:         envDuration("CHANNEL_CACHE_TTL", 10*time.Minute),
+	}
+	for _, url := range relayURLs() {
+		refresh := make(chan struct{}, 1)
+		c.refresh = append(c.refresh, refresh)
+		go c.follow(url, refresh)
+	}
+	go c.expire()
+	return c
+}
+
+// Close stops all live subscriptions and drops every entry.
+func (c *ChannelCache) Close() {
+	c.cancel()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, entry := range c.entries {
+		c.evict(entry)
+	}
+}
+
+// Page returns a page of channel messages, from the cache when it can answer
+// the request and from the relays otherwise. hit reports which one it was.
+func (c *ChannelCache) Page(ctx context.Context, channelID string, page channelPage) (ChannelEventsResponse, bool, error) {
+	entry, seeded, err := c.entry(ctx, channelID)
+	if err != nil {
+		log.Printf("Channel cache seed failed for channelId=%s: %v", channelID, err)
+	} else if events, next, ok := c.serve(entry, page); ok {
+		return ChannelEventsResponse{Videos: videosFromEvents(events), Next: next}, !seeded, nil
+	}
+
+	fetched, err := FetchEvents(ctx, page.filter(channelID))
+	if err != nil {
+		return ChannelEventsResponse{}, false, err
+	}
+	events, next := page.apply(verifyChannelEvents(fetched.Events, channelID))
+	return ChannelEventsResponse{Videos: videosFromEvents(events), Next: next, Relays: &fetched.Relays}, false, nil
+}
+
+// entry returns the cache entry for a channel, creating and seeding it if
+// needed. seeded reports whether this call did the seeding.
+func (c *ChannelCache) entry(ctx context.Context, channelID string) (entry *cacheEntry, seeded bool, err error) {
+	c.mu.Lock()
+	entry, ok := c.entries[channelID]
+	if !ok {
+		entry = &cacheEntry{
+			channelID: channelID,
+			ids:       make(map[string]bool),
+			ready:     make(chan struct{}),
+		}
+		c.entries[channelID] = entry
+		c.enforceLimits(entry)
+	}
+	entry.lastAccess = time.Now()
+	c.mu.Unlock()
+
+	if ok {
+		select {
+		case <-entry.ready:
+			return entry, false, entry.err
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		}
+	}
+
+	c.seed(entry)
+	return entry, true, entry.err
+}
+
+// seed loads the newest messages for an entry and starts following the channel.
+func (c *ChannelCache) seed(entry *cacheEntry) {
+	defer close(entry.ready)
+
+	entry.seededAt = nostr.Now()
+	seedCtx, cancel := context.WithTimeout(c.ctx, cacheSeedTimeout)
+	defer cancel()
+
+	fetched, err := FetchEvents(seedCtx, nostr.Filter{
+		Kinds: []int{nostr.KindChannelMessage},
+		Tags:  nostr.TagMap{"e": []string{entry.channelID}},
+		Limit: c.maxEvents,
+	})
+	events := verifyChannelEvents(fetched.Events, entry.channelID)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err != nil {
+		entry.err = err
+		if c.entries[entry.channelID] == entry {
+			delete(c.entries, entry.channelID)
+		}
+		return
+	}
+
+	// The entry may have been evicted while we were fetching.
+	if c.entries[entry.channelID] != entry {
+		return
+	}
+
+	for _, ev := range events {
+		c.add(entry, ev)
+	}
+	// A short history is only the whole channel if no relay failed to answer.
+	entry.complete = len(fetched.Events) < c.maxEvents && len(fetched.Relays.Failed) == 0
+	c.enforceLimits(entry)
+
+	entry.following = true
+	c.followsChanged()
+}
+
+// follow keeps one subscription on a relay to new messages in every followed
+// channel until the cache is closed. It resubscribes when the followed set
+// changes and, after relayKeepAlivePeriod, when the subscription drops.
+func (c *ChannelCache) follow(url string, refresh <-chan struct{}) {
+	since := nostr.Now()
+	subscribed := make(map[string]bool)
+	filters := func() nostr.Filters {
+		// Channels new to the subscription need their messages since they
+		// were seeded.
+		ids, filterSince := c.followed(subscribed, since)
+		subscribed = make(map[string]bool, len(ids))
+		for _, id := range ids {
+			subscribed[id] = true
+		}
+		// The next subscription picks up from this one's start; add drops
+		// the messages both see.
+		since = nostr.Now()
+		if len(ids) == 0 {
+			return nil
+		}
+		return nostr.Filters{{
+			Kinds: []int{nostr.KindChannelMessage},
+			Tags:  nostr.TagMap{"e": ids},
+			Since: &filterSince,
+		}}
+	}
+	c.pool.Follow(c.ctx, url, filters, refresh, c.deliver)
+}
+
+// followed returns the channels to subscribe to and the since to use: the
+// given one, or the seed time of a channel missing from subscribed.
+func (c *ChannelCache) followed(subscribed map[string]bool, since nostr.Timestamp) ([]string, nostr.Timestamp) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var ids []string
+	for id, entry := range c.entries {
+		if !entry.following {
+			continue
+		}
+		ids = append(ids, id)
+		if !subscribed[id] && entry.seededAt < since {
+			since = entry.seededAt
+		}
+	}
+	sort.Strings(ids)
+	return ids, since
+}
+
+// deliver adds a live message to the entry of the channel it belongs to.
+func (c *ChannelCache) deliver(ev *nostr.Event) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, tag := range ev.Tags {
+		if len(tag) < 2 || tag[0] != "e" {
+			continue
+		}
+		entry, ok := c.entries[tag[1]]
+		if !ok || !entry.following {
+			continue
+		}
+		if err := verifyChannelEvent(ev, entry.channelID); err != nil {
+			countRejection(err)
+			return
+		}
+		c.add(entry, *ev)
+		c.enforceLimits(entry)
+		return
+	}
+}
+
+// followsChanged tells every relay's follower to resubscribe.
+func (c *ChannelCache) followsChanged() {
+	for _, refresh := range c.refresh {
+		select {
+		case refresh <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// serve answers a page from the entry if the cached window covers it: either
+// the entry holds the channel's whole history or it has more matching events
+// than the page needs.
+func (c *ChannelCache) serve(entry *cacheEntry, page channelPage) ([]nostr.Event, string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var candidates []nostr.Event
+	for _, ev := range entry.events {
+		if page.cursor != nil && !page.cursor.before(ev) {
+			continue
+		}
+		if page.until != nil && ev.CreatedAt > *page.until {
+			continue
+		}
+		if page.since != nil && ev.CreatedAt < *page.since {
+			break
+		}
+		candidates = append(candidates, ev)
+	}
+
+	// An entry holding events older than since has everything the page can match.
+	coversSince := page.since != nil && len(entry.events) > 0 &&
+		entry.events[len(entry.events)-1].CreatedAt < *page.since
+	if len(candidates) <= page.limit && !entry.complete && !coversSince {
+		return nil, "", false
+	}
+
+	events, next := page.apply(candidates)
+	return events, next, true
+}
+
+// add inserts an event into the entry, keeping newest-first order.
+// Callers must hold c.mu.
+func (c *ChannelCache) add(entry *cacheEntry, ev nostr.Event) {
+	if entry.ids[ev.ID] {
+		return
+	}
+
+	i := sort.Search(len(entry.events), func(i int) bool {
+		e := entry.events[i]
+		if e.CreatedAt != ev.CreatedAt {
+			return e.CreatedAt < ev.CreatedAt
+		}
+		return e.ID < ev.ID
+	})
+	entry.events = append(entry.events, nostr.Event{})
+	copy(entry.events[i+1:], entry.events[i:])
+	entry.events[i] = ev
+	entry.ids[ev.ID] = true
+
+	size := eventSize(ev)
+	entry.bytes += size
+	c.bytes += size
+
+	// Drop the oldest messages beyond the per-channel bound.
+	for len(entry.events) > c.maxEvents {
+		oldest := entry.events[len(entry.events)-1]
+		entry.events = entry.events[:len(entry.events)-1]
+		delete(entry.ids, oldest.ID)
+		size := eventSize(oldest)
+		entry.bytes -= size
+		c.bytes -= size
+		entry.complete = false
+	}
+}
+
+// enforceLimits evicts least recently used entries other than keep until the
+// cache is within its channel and byte bounds. Callers must hold c.mu.
+func (c *ChannelCache) enforceLimits(keep *cacheEntry) {
+	for len(c.entries) > c.maxChannels || c.bytes > c.maxBytes {
+		var lru *cacheEntry
+		for _, entry := range c.entries {
+			if entry != keep && (lru == nil || entry.lastAccess.Before(lru.lastAccess)) {
+				lru = entry
+			}
+		}
+		if lru == nil {
+			return
+		}
+		c.evict(lru)
+	}
+}
+
+// evict drops an entry and stops following it. Callers must hold c.mu.
+func (c *ChannelCache) evict(entry *cacheEntry) {
+	if entry.following {
+		entry.following = false
+		c.followsChanged()
+	}
+	c.bytes -= entry.bytes
+	delete(c.entries, entry.channelID)
+}
+
+// expire periodically evicts entries that have not been read within the TTL.
+func (c *ChannelCache) expire() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		c.mu.Lock()
+		for _, entry := range c.entries {
+			if time.Since(entry.lastAccess) > c.ttl && entry.following {
+				c.evict(entry)
+			}
+		}
+		c.mu.Unlock()
+	}
+}
+
+// eventSize approximates the memory held by an event.
+func eventSize(ev nostr.Event) int {
+	size := 256 + len(ev.Content)
+	for _, tag := range ev.Tags {
+		for _, v := range tag {
+			size += len(v) + 16
+		}
+	}
+	return size
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestChannelCacheFollowedBatchesChannels(t *testing.T) {
+	c := &ChannelCache{entries: make(map[string]*cacheEntry)}
+	for i, seededAt := range []nostr.Timestamp{100, 200, 300} {
+		id := fmt.Sprintf("%064x", i)
+		c.entries[id] = &cacheEntry{channelID: id, following: true, seededAt: seededAt}
+	}
+	// Still seeding, so not followed yet
+	pending := fmt.Sprintf("%064x", 9)
+	c.entries[pending] = &cacheEntry{channelID: pending, seededAt: 50}
+
+	ids, since := c.followed(nil, 1000)
+	if len(ids) != 3 {
+		t.Fatalf("followed %d channels, want 3", len(ids))
+	}
+	if since != 100 {
+		t.Errorf("since = %d for a fresh subscription, want the oldest seed 100", since)
+	}
+
+	subscribed := map[string]bool{ids[0]: true, ids[1]: true}
+	if _, since := c.followed(subscribed, 1000); since != 300 {
+		t.Errorf("since = %d with one new channel, want its seed 300", since)
+	}
+
+	subscribed[ids[2]] = true
+	if _, since := c.followed(subscribed, 1000); since != 1000 {
+		t.Errorf("since = %d with no new channel, want 1000", since)
+	}
+}
+
+func TestChannelCacheEvictStopsFollowing(t *testing.T) {
+	refresh := make(chan struct{}, 1)
+	c := &ChannelCache{entries: make(map[string]*cacheEntry), refresh: []chan struct{}{refresh}}
+	id := fmt.Sprintf("%064x", 1)
+	entry := &cacheEntry{channelID: id, following: true}
+	c.entries[id] = entry
+
+	c.evict(entry)
+	if entry.following {
+		t.Error("evicted entry is still followed")
+	}
+	select {
+	case <-refresh:
+	default:
+		t.Error("evict did not ask the followers to resubscribe")
+	}
+	if ids, _ := c.followed(nil, 0); len(ids) != 0 {
+		t.Errorf("followed = %v after eviction, want none", ids)
+	}
+}
+
+func TestChannelCacheSeedCompleteOnlyWhenEveryRelayAnswered(t *testing.T) {
+	newCache := func() *ChannelCache {
+		return &ChannelCache{
+			ctx:         context.Background(),
+			entries:     make(map[string]*cacheEntry),
+			maxChannels: 10,
+			maxEvents:   10,
+			maxBytes:    1 << 20,
+		}
+	}
+	seed := func(c *ChannelCache) *cacheEntry {
+		id := fmt.Sprintf("%064x", 1)
+		entry := &cacheEntry{channelID: id, ids: make(map[string]bool), ready: make(chan struct{})}
+		c.entries[id] = entry
+		c.seed(entry)
+		return entry
+	}
+
+	stubRelays(t, map[string]func() ([]nostr.Event, error){
+		"wss://a.test": func() ([]nostr.Event, error) { return []nostr.Event{{ID: "1"}}, nil },
+		"wss://b.test": func() ([]nostr.Event, error) { return nil, nil },
+	})
+	if entry := seed(newCache()); entry.err != nil || !entry.complete {
+		t.Errorf("every relay answered: complete = %v, err = %v, want a complete entry", entry.complete, entry.err)
+	}
+
+	stubRelays(t, map[string]func() ([]nostr.Event, error){
+		"wss://a.test": func() ([]nostr.Event, error) { return []nostr.Event{{ID: "1"}}, nil },
+		"wss://b.test": func() ([]nostr.Event, error) { return nil, errors.New("timed out") },
+	})
+	if entry := seed(newCache()); entry.err != nil || entry.complete {
+		t.Errorf("one relay failed: complete = %v, err = %v, want an incomplete entry", entry.complete, entry.err)
+	}
+}
@@ -0,0 +1,326 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// defaultLeadIcon is used for channels that do not specify an icon.
+const defaultLeadIcon = "📡"
+
+const (
+	// maxChannelLeadStates bounds how many channels the sync remembers.
+	maxChannelLeadStates = 10000
+	// maxPendingChannelMetadata bounds metadata waiting for its channel.
+	maxPendingChannelMetadata = 1000
+)
+
+// channelMetadata is the JSON content of kind 40 and 41 events (NIP-28),
+// plus the optional icon our app adds.
+type channelMetadata struct {
+	Name    string `json:"name"`
+	About   string `json:"about"`
+	Picture string `json:"picture"`
+	Icon    string `json:"icon"`
+}
+
+// channelLeadState is what we know about a channel from its events so far.
+type channelLeadState struct {
+	creator    string
+	name       string
+	icon       string
+	coordinate *Coordinate
+	updatedAt  nostr.Timestamp
+}
+
+// ChannelLeadSync materialises located NIP-28 channels as leads. It only
+// follows channels from HUB_LEAD_AUTHORS or tagged with one of
+// HUB_LEAD_TAGS, so arbitrary relay users cannot flood the map. The least
+// recently updated channels are forgotten beyond maxChannelLeadStates.
+type ChannelLeadSync struct {
+	store    *LeadStore
+	pool     *RelayPool
+	channels map[string]*channelLeadState
+	// pending holds metadata that arrived before its channel creation event,
+	// the newest per channel and author, keyed by pendingKey. Only the
+	// creator's is applied once the channel shows up.
+	pending map[string]*nostr.Event
+}
+
+// NewChannelLeadSync creates a sync that reads channel events from pool and
+// writes derived leads into store.
+func NewChannelLeadSync(store *LeadStore, pool *RelayPool) *ChannelLeadSync {
+	return &ChannelLeadSync{
+		store:    store,
+		pool:     pool,
+		channels: make(map[string]*channelLeadState),
+		pending:  make(map[string]*nostr.Event),
+	}
+}
+
+// channelLeadFilters returns the subscription filters for channel creation and
+// metadata events restricted to HUB_LEAD_AUTHORS and to channels tagged with
+// one of HUB_LEAD_TAGS (comma-separated "t" tags). It reports false when
+// neither is set.
+func channelLeadFilters() (nostr.Filters, bool) {
+	filter := nostr.Filter{
+		Kinds: []int{nostr.KindChannelCreation, nostr.KindChannelMetadata},
+	}
+	filter.Authors = splitList(os.Getenv("HUB_LEAD_AUTHORS"))
+	if tags := splitList(os.Getenv("HUB_LEAD_TAGS")); len(tags) > 0 {
+		filter.Tags = nostr.TagMap{"t": tags}
+	}
+	if len(filter.Authors) == 0 && len(filter.Tags) == 0 {
+		return nil, false
+	}
+	return nostr.Filters{filter}, true
+}
+
+// splitList splits a comma-separated setting, dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Run subscribes to every configured relay through the pool and processes
+// channel events until ctx is cancelled. Events from all relays are handled
+// on this goroutine, so duplicates collapse naturally. Without
+// HUB_LEAD_AUTHORS or HUB_LEAD_TAGS it does nothing.
+func (s *ChannelLeadSync) Run(ctx context.Context) {
+	filters, ok := channelLeadFilters()
+	if !ok {
+		log.Println("⚠️ Channel lead sync disabled: set HUB_LEAD_AUTHORS or HUB_LEAD_TAGS")
+		return
+	}
+
+	events := make(chan *nostr.Event)
+	for _, url := range relayURLs() {
+		go s.pool.Follow(ctx, url, func() nostr.Filters { return filters }, nil, func(ev *nostr.Event) {
+			select {
+			case events <- ev:
+			case <-ctx.Done():
+			}
+		})
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-events:
+			s.HandleEvent(ev)
+		}
+	}
+}
+
+// HandleEvent applies a kind 40 or 41 event to the derived leads.
+func (s *ChannelLeadSync) HandleEvent(ev *nostr.Event) {
+	switch ev.Kind {
+	case nostr.KindChannelCreation:
+		s.handleCreation(ev)
+	case nostr.KindChannelMetadata:
+		s.handleMetadata(ev)
+	}
+}
+
+func (s *ChannelLeadSync) handleCreation(ev *nostr.Event) {
+	if _, ok := s.channels[ev.ID]; ok {
+		return
+	}
+
+	state := &channelLeadState{
+		creator:   ev.PubKey,
+		icon:      defaultLeadIcon,
+		updatedAt: ev.CreatedAt,
+	}
+	state.apply(ev)
+	s.evictChannel()
+	s.channels[ev.ID] = state
+
+	if pending, ok := s.pending[pendingKey(ev.ID, ev.PubKey)]; ok {
+		state.update(pending)
+	}
+	for key := range s.pending {
+		if strings.HasPrefix(key, ev.ID+" ") {
+			delete(s.pending, key)
+		}
+	}
+	s.materialise(ev.ID, state)
+}
+
+func (s *ChannelLeadSync) handleMetadata(ev *nostr.Event) {
+	channelID := channelRef(ev)
+	if channelID == "" {
+		return
+	}
+
+	state, ok := s.channels[channelID]
+	if !ok {
+		// Keep each author's newest metadata until the channel shows up and
+		// tells us which author is its creator.
+		key := pendingKey(channelID, ev.PubKey)
+		pending, ok := s.pending[key]
+		if !ok {
+			s.evictPending()
+		}
+		if !ok || ev.CreatedAt > pending.CreatedAt {
+			s.pending[key] = ev
+		}
+		return
+	}
+
+	if state.update(ev) {
+		s.materialise(channelID, state)
+	}
+}
+
+// pendingKey is the key of an author's pending metadata for a channel.
+func pendingKey(channelID, pubkey string) string {
+	return channelID + " " + pubkey
+}
+
+// evictChannel forgets the least recently updated channel when the limit is
+// reached. Its lead stays in the store.
+func (s *ChannelLeadSync) evictChannel() {
+	if len(s.channels) < maxChannelLeadStates {
+		return
+	}
+	var oldest string
+	for id, state := range s.channels {
+		if oldest == "" || state.updatedAt < s.channels[oldest].updatedAt {
+			oldest = id
+		}
+	}
+	delete(s.channels, oldest)
+}
+
+// evictPending drops the oldest pending metadata when the limit is reached.
+func (s *ChannelLeadSync) evictPending() {
+	if len(s.pending) < maxPendingChannelMetadata {
+		return
+	}
+	var oldest string
+	for id, ev := range s.pending {
+		if oldest == "" || ev.CreatedAt < s.pending[oldest].CreatedAt {
+			oldest = id
+		}
+	}
+	delete(s.pending, oldest)
+}
+
+// materialise creates or updates the lead for a channel once it has a location.
+func (s *ChannelLeadSync) materialise(channelID string, state *channelLeadState) {
+	if state.coordinate == nil {
+		return
+	}
+
+	lead, err := s.store.FindByChannel(channelID)
+	if err != nil {
+		lead = Lead{ID: leadIDForChannel(channelID), ChannelID: channelID, Pubkey: state.creator}
+	}
+
+	updated := lead
+	updated.Name = state.name
+	if updated.Name == "" {
+		updated.Name = "Channel " + channelID[:8]
+	}
+	updated.Icon = state.icon
+	updated.Coordinate = *state.coordinate
+	if err == nil && updated == lead {
+		return
+	}
+
+	if _, err := s.store.Upsert(updated); err != nil {
+		log.Printf("Failed to store lead for channel %s: %v", channelID, err)
+		return
+	}
+	log.Printf("📍 Lead %q synced from channel %s", updated.Name, channelID)
+}
+
+// update applies a kind 41 event to the state, reporting false if it is not
+// from the channel's creator (NIP-28) or older than what the state holds.
+func (st *channelLeadState) update(ev *nostr.Event) bool {
+	if ev.PubKey != st.creator || ev.CreatedAt < st.updatedAt {
+		return false
+	}
+	st.updatedAt = ev.CreatedAt
+	st.apply(ev)
+	return true
+}
+
+// apply merges the metadata and location carried by ev into the state.
+func (st *channelLeadState) apply(ev *nostr.Event) {
+	var meta channelMetadata
+	if err := json.Unmarshal([]byte(ev.Content), &meta); err == nil {
+		if meta.Name != "" {
+			st.name = meta.Name
+		}
+		if meta.Icon != "" {
+			st.icon = meta.Icon
+		}
+	}
+
+	if c, ok := eventCoordinate(ev.Tags); ok {
+		st.coordinate = &c
+	}
+}
+
+// channelRef returns the channel a kind 41 event refers to.
+func channelRef(ev *nostr.Event) string {
+	for _, tag := range ev.Tags {
+		if len(tag) >= 2 && tag[0] == "e" && isValidChannelID(tag[1]) {
+			return tag[1]
+		}
+	}
+	return ""
+}
+
+// eventCoordinate extracts a location from "g" (geohash) or "lat"/"lon" tags.
+// Explicit lat/lon takes precedence; otherwise the most precise geohash wins.
+func eventCoordinate(tags nostr.Tags) (Coordinate, bool) {
+	var geohash, lat, lon string
+	for _, tag := range tags {
+		if len(tag) < 2 {
+			continue
+		}
+		switch tag[0] {
+		case "g":
+			if len(tag[1]) > len(geohash) {
+				geohash = strings.ToLower(tag[1])
+			}
+		case "lat":
+			lat = tag[1]
+		case "lon":
+			lon = tag[1]
+		}
+	}
+
+	if lat != "" && lon != "" {
+		latitude, latErr := parseFiniteFloat(lat)
+		longitude, lonErr := parseFiniteFloat(lon)
+		c := Coordinate{Latitude: latitude, Longitude: longitude}
+		if latErr == nil && lonErr == nil && validateCoordinate(c) == nil {
+			return c, true
+		}
+	}
+
+	if len(geohash) > geohashPrecision {
+		geohash = geohash[:geohashPrecision]
+	}
+	if geohash != "" {
+		if cell, err := decodeGeohash(geohash); err == nil {
+			return cell.Center(), true
+		}
+	}
+	return Coordinate{}, false
+}
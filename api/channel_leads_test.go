@@ -0,0 +1,182 @@
+package api
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestChannelLeadFiltersRequireAllowlist(t *testing.T) {
+	t.Setenv("HUB_LEAD_AUTHORS", "")
+	t.Setenv("HUB_LEAD_TAGS", "")
+	if _, ok := channelLeadFilters(); ok {
+		t.Fatal("channelLeadFilters enabled without authors or tags")
+	}
+
+	t.Setenv("HUB_LEAD_TAGS", " skatepay, ,spots")
+	filters, ok := channelLeadFilters()
+	if !ok {
+		t.Fatal("channelLeadFilters disabled with HUB_LEAD_TAGS set")
+	}
+	if got := strings.Join(filters[0].Tags["t"], ","); got != "skatepay,spots" {
+		t.Errorf("t tags = %q, want skatepay,spots", got)
+	}
+	if len(filters[0].Authors) != 0 {
+		t.Errorf("authors = %v, want none", filters[0].Authors)
+	}
+}
+
+func TestChannelLeadSyncMaterialisesLocatedChannel(t *testing.T) {
+	store, err := NewLeadStore(filepath.Join(t.TempDir(), "leads.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewChannelLeadSync(store, nil)
+
+	channelID := strings.Repeat("a", 64)
+	creator := strings.Repeat("b", 64)
+
+	// Metadata arriving first waits for its channel
+	s.HandleEvent(&nostr.Event{
+		ID:        strings.Repeat("c", 64),
+		PubKey:    creator,
+		Kind:      nostr.KindChannelMetadata,
+		CreatedAt: 2,
+		Content:   `{"name":"Venice"}`,
+		Tags:      nostr.Tags{{"e", channelID}, {"g", "9q5c"}},
+	})
+	if len(s.pending) != 1 {
+		t.Fatalf("pending = %d, want 1", len(s.pending))
+	}
+
+	s.HandleEvent(&nostr.Event{
+		ID:        channelID,
+		PubKey:    creator,
+		Kind:      nostr.KindChannelCreation,
+		CreatedAt: 1,
+		Content:   `{"name":"Skatepark"}`,
+	})
+	lead, err := store.FindByChannel(channelID)
+	if err != nil {
+		t.Fatalf("FindByChannel: %v", err)
+	}
+	if lead.Name != "Venice" || lead.Pubkey != creator {
+		t.Errorf("lead = %+v, want name Venice by %s", lead, creator)
+	}
+	if len(s.pending) != 0 {
+		t.Errorf("pending = %d after creation, want 0", len(s.pending))
+	}
+
+	// Only the creator may update the metadata
+	s.HandleEvent(&nostr.Event{
+		ID:        strings.Repeat("d", 64),
+		PubKey:    strings.Repeat("e", 64),
+		Kind:      nostr.KindChannelMetadata,
+		CreatedAt: 3,
+		Content:   `{"name":"Hijacked"}`,
+		Tags:      nostr.Tags{{"e", channelID}},
+	})
+	if lead, _ := store.FindByChannel(channelID); lead.Name != "Venice" {
+		t.Errorf("lead renamed to %q by another pubkey", lead.Name)
+	}
+}
+
+func TestChannelLeadSyncBoundsState(t *testing.T) {
+	s := NewChannelLeadSync(nil, nil)
+
+	for i := 0; i < maxChannelLeadStates+10; i++ {
+		s.HandleEvent(&nostr.Event{
+			ID:        fmt.Sprintf("%064x", i),
+			Kind:      nostr.KindChannelCreation,
+			CreatedAt: nostr.Timestamp(i),
+		})
+	}
+	if len(s.channels) != maxChannelLeadStates {
+		t.Errorf("channels = %d, want %d", len(s.channels), maxChannelLeadStates)
+	}
+	if _, ok := s.channels[fmt.Sprintf("%064x", 0)]; ok {
+		t.Error("oldest channel was not evicted")
+	}
+
+	for i := 0; i < maxPendingChannelMetadata+10; i++ {
+		s.HandleEvent(&nostr.Event{
+			Kind:      nostr.KindChannelMetadata,
+			CreatedAt: nostr.Timestamp(i),
+			Tags:      nostr.Tags{{"e", fmt.Sprintf("%064x", maxChannelLeadStates+100+i)}},
+		})
+	}
+	if len(s.pending) != maxPendingChannelMetadata {
+		t.Errorf("pending = %d, want %d", len(s.pending), maxPendingChannelMetadata)
+	}
+}
+
+func TestChannelLeadSyncIgnoresPendingMetadataFromOthers(t *testing.T) {
+	store, err := NewLeadStore(filepath.Join(t.TempDir(), "leads.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewChannelLeadSync(store, nil)
+
+	channelID := strings.Repeat("a", 64)
+	creator := strings.Repeat("b", 64)
+	metadata := func(id, pubkey string, createdAt nostr.Timestamp, name string) *nostr.Event {
+		return &nostr.Event{
+			ID:        id,
+			PubKey:    pubkey,
+			Kind:      nostr.KindChannelMetadata,
+			CreatedAt: createdAt,
+			Content:   `{"name":"` + name + `"}`,
+			Tags:      nostr.Tags{{"e", channelID}},
+		}
+	}
+
+	// The creator's metadata survives a newer one from someone else, and
+	// neither keeps the channel from being materialised.
+	s.HandleEvent(metadata(strings.Repeat("c", 64), creator, 2, "Venice"))
+	s.HandleEvent(metadata(strings.Repeat("d", 64), strings.Repeat("e", 64), 5, "Hijacked"))
+	s.HandleEvent(&nostr.Event{
+		ID:        channelID,
+		PubKey:    creator,
+		Kind:      nostr.KindChannelCreation,
+		CreatedAt: 1,
+		Content:   `{"name":"Skatepark"}`,
+		Tags:      nostr.Tags{{"g", "9q5c"}},
+	})
+
+	lead, err := store.FindByChannel(channelID)
+	if err != nil {
+		t.Fatalf("FindByChannel: %v", err)
+	}
+	if lead.Name != "Venice" {
+		t.Errorf("lead name = %q, want the creator's Venice", lead.Name)
+	}
+	if len(s.pending) != 0 {
+		t.Errorf("pending = %d after creation, want 0", len(s.pending))
+	}
+
+	// Metadata older than the creation event is ignored, but the channel
+	// is still materialised.
+	other := strings.Repeat("f", 64)
+	s.HandleEvent(&nostr.Event{
+		ID:        strings.Repeat("1", 64),
+		PubKey:    creator,
+		Kind:      nostr.KindChannelMetadata,
+		CreatedAt: 1,
+		Content:   `{"name":"Stale"}`,
+		Tags:      nostr.Tags{{"e", other}},
+	})
+	s.HandleEvent(&nostr.Event{
+		ID:        other,
+		PubKey:    creator,
+		Kind:      nostr.KindChannelCreation,
+		CreatedAt: 10,
+		Content:   `{"name":"Fresh"}`,
+		Tags:      nostr.Tags{{"g", "9q5c"}},
+	})
+	if lead, err := store.FindByChannel(other); err != nil || lead.Name != "Fresh" {
+		t.Errorf("FindByChannel(other) = %+v, %v, want Fresh", lead, err)
+	}
+}
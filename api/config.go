@@ -0,0 +1,112 @@
+package api
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"hub/broadcast"
+	"hub/storage"
+)
+
+// envInt reads an integer environment variable, falling back to def when it
+// is unset or invalid.
+func envInt(name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("⚠️ Invalid %s=%q, using %d", name, v, def)
+		return def
+	}
+	return n
+}
+
+// envDuration reads a duration environment variable such as "90s" or "10m",
+// falling back to def when it is unset or invalid.
+func envDuration(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Printf("⚠️ Invalid %s=%q, using %v", name, v, def)
+		return def
+	}
+	return d
+}
+
+// uploadMaxBytes is the largest upload accepted, from UPLOAD_MAX_BYTES.
+func uploadMaxBytes() int64 {
+	return int64(envInt("UPLOAD_MAX_BYTES", 5<<30))
+}
+
+// blobStoreConfig reads the storage backend configuration:
+//
+//	STORAGE_BACKEND       "s3" (default) or "local"
+//	S3_BUCKET, AWS_REGION, S3_ENDPOINT    S3 backend
+//	STORAGE_LOCAL_DIR     local backend directory (default "blobs")
+//	STORAGE_SIGNING_KEY   signs local presigned URLs
+//	MEDIA_BASE_URL        public URL objects are served from
+//	UPLOAD_MAX_BYTES      largest accepted upload (default 5 GiB)
+func blobStoreConfig() storage.Config {
+	cfg := storage.Config{
+		Backend:    os.Getenv("STORAGE_BACKEND"),
+		Bucket:     os.Getenv("S3_BUCKET"),
+		Region:     os.Getenv("AWS_REGION"),
+		Endpoint:   os.Getenv("S3_ENDPOINT"),
+		Dir:        os.Getenv("STORAGE_LOCAL_DIR"),
+		SigningKey: []byte(os.Getenv("STORAGE_SIGNING_KEY")),
+		PublicURL:  os.Getenv("MEDIA_BASE_URL"),
+
+		MaxUploadBytes: uploadMaxBytes(),
+	}
+
+	if cfg.Backend == "local" {
+		if cfg.Dir == "" {
+			cfg.Dir = "blobs"
+		}
+		if cfg.PublicURL == "" {
+			base := strings.TrimRight(os.Getenv("PUBLIC_URL"), "/")
+			if base == "" {
+				port := os.Getenv("PORT")
+				if port == "" {
+					port = "8080"
+				}
+				base = "http://localhost:" + port
+			}
+			cfg.PublicURL = base + "/blobs"
+		}
+	}
+	return cfg
+}
+
+// storageBucket is the bucket name tokens are issued for. The local backend
+// has no real bucket, so it answers to "local" unless S3_BUCKET is set.
+func storageBucket() string {
+	if bucket := os.Getenv("S3_BUCKET"); bucket != "" {
+		return bucket
+	}
+	if os.Getenv("STORAGE_BACKEND") == "local" {
+		return "local"
+	}
+	return ""
+}
+
+// broadcastConfig reads the WebSocket broadcast backend configuration:
+//
+//	WS_BROADCAST_BACKEND   "memory" (default) or "redis"
+//	REDIS_URL              redis://[user:password@]host:port[/db]
+//	WS_BROADCAST_CHANNEL   Redis pub/sub channel (default "hub:ws")
+func broadcastConfig() broadcast.Config {
+	return broadcast.Config{
+		Backend:  os.Getenv("WS_BROADCAST_BACKEND"),
+		RedisURL: os.Getenv("REDIS_URL"),
+		Channel:  os.Getenv("WS_BROADCAST_CHANNEL"),
+	}
+}
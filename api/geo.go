@@ -0,0 +1,390 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+const (
+	earthRadiusMeters = 6371000.0
+	metersPerDegree   = 111320.0
+
+	// geohashPrecision is the precision leads are indexed at (~3.7cm cells).
+	geohashPrecision = 12
+	geohashAlphabet  = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+	// maxCoverCells bounds how many geohash cells a single query may scan.
+	maxCoverCells = 32
+	// maxCoverPrecision is the finest precision used when covering a query area.
+	maxCoverPrecision = 9
+
+	maxRadiusMeters = 20000000.0
+)
+
+// BoundingBox is a latitude/longitude rectangle.
+type BoundingBox struct {
+	MinLatitude  float64
+	MinLongitude float64
+	MaxLatitude  float64
+	MaxLongitude float64
+}
+
+// Contains reports whether c lies inside the box, edges included.
+func (b BoundingBox) Contains(c Coordinate) bool {
+	return c.Latitude >= b.MinLatitude && c.Latitude <= b.MaxLatitude &&
+		c.Longitude >= b.MinLongitude && c.Longitude <= b.MaxLongitude
+}
+
+// Center returns the midpoint of the box.
+func (b BoundingBox) Center() Coordinate {
+	return Coordinate{
+		Latitude:  (b.MinLatitude + b.MaxLatitude) / 2,
+		Longitude: (b.MinLongitude + b.MaxLongitude) / 2,
+	}
+}
+
+// distanceMeters returns the great-circle distance between two coordinates.
+func distanceMeters(a, b Coordinate) float64 {
+	lat1 := a.Latitude * math.Pi / 180
+	lat2 := b.Latitude * math.Pi / 180
+	dLat := lat2 - lat1
+	dLon := (b.Longitude - a.Longitude) * math.Pi / 180
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusMeters * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// boundingBoxesAround returns boxes that together contain every point within
+// radius meters of c. A circle crossing the antimeridian is split into one
+// box on each side of it.
+func boundingBoxesAround(c Coordinate, radius float64) []BoundingBox {
+	dLat := radius / metersPerDegree
+	box := BoundingBox{
+		MinLatitude:  math.Max(-90, c.Latitude-dLat),
+		MaxLatitude:  math.Min(90, c.Latitude+dLat),
+		MinLongitude: -180,
+		MaxLongitude: 180,
+	}
+
+	// Near the poles or for huge radii every longitude is in range.
+	cosLat := math.Cos(math.Max(math.Abs(box.MinLatitude), math.Abs(box.MaxLatitude)) * math.Pi / 180)
+	if cosLat <= 0 {
+		return []BoundingBox{box}
+	}
+	dLon := dLat / cosLat
+	if dLon >= 180 {
+		return []BoundingBox{box}
+	}
+
+	box.MinLongitude = c.Longitude - dLon
+	box.MaxLongitude = c.Longitude + dLon
+	switch {
+	case box.MinLongitude < -180:
+		wrapped := box
+		wrapped.MinLongitude, wrapped.MaxLongitude = box.MinLongitude+360, 180
+		box.MinLongitude = -180
+		return []BoundingBox{box, wrapped}
+	case box.MaxLongitude > 180:
+		wrapped := box
+		wrapped.MinLongitude, wrapped.MaxLongitude = -180, box.MaxLongitude-360
+		box.MaxLongitude = 180
+		return []BoundingBox{box, wrapped}
+	}
+	return []BoundingBox{box}
+}
+
+// parseBoundingBox parses "minLon,minLat,maxLon,maxLat" (GeoJSON order).
+func parseBoundingBox(s string) (BoundingBox, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return BoundingBox{}, errors.New("bbox must be minLon,minLat,maxLon,maxLat")
+	}
+
+	var values [4]float64
+	for i, part := range parts {
+		v, err := parseFiniteFloat(strings.TrimSpace(part))
+		if err != nil {
+			return BoundingBox{}, fmt.Errorf("invalid bbox value %q", part)
+		}
+		values[i] = v
+	}
+
+	box := BoundingBox{
+		MinLongitude: values[0],
+		MinLatitude:  values[1],
+		MaxLongitude: values[2],
+		MaxLatitude:  values[3],
+	}
+	if err := validateCoordinate(Coordinate{Latitude: box.MinLatitude, Longitude: box.MinLongitude}); err != nil {
+		return BoundingBox{}, err
+	}
+	if err := validateCoordinate(Coordinate{Latitude: box.MaxLatitude, Longitude: box.MaxLongitude}); err != nil {
+		return BoundingBox{}, err
+	}
+	if box.MinLatitude > box.MaxLatitude {
+		return BoundingBox{}, errors.New("bbox minLat must not exceed maxLat")
+	}
+	if box.MinLongitude > box.MaxLongitude {
+		return BoundingBox{}, errors.New("bbox crossing the antimeridian is not supported")
+	}
+	return box, nil
+}
+
+// geohashBits returns the number of longitude and latitude bits in a geohash of the given precision.
+func geohashBits(precision int) (lonBits, latBits int) {
+	bits := precision * 5
+	return (bits + 1) / 2, bits / 2
+}
+
+// encodeGeohash encodes c as a geohash with the given number of characters.
+func encodeGeohash(c Coordinate, precision int) string {
+	minLat, maxLat := -90.0, 90.0
+	minLon, maxLon := -180.0, 180.0
+
+	var hash strings.Builder
+	bit, ch := 0, 0
+	even := true
+	for hash.Len() < precision {
+		if even {
+			mid := (minLon + maxLon) / 2
+			if c.Longitude >= mid {
+				ch |= 1 << (4 - bit)
+				minLon = mid
+			} else {
+				maxLon = mid
+			}
+		} else {
+			mid := (minLat + maxLat) / 2
+			if c.Latitude >= mid {
+				ch |= 1 << (4 - bit)
+				minLat = mid
+			} else {
+				maxLat = mid
+			}
+		}
+		even = !even
+
+		if bit < 4 {
+			bit++
+			continue
+		}
+		hash.WriteByte(geohashAlphabet[ch])
+		bit, ch = 0, 0
+	}
+	return hash.String()
+}
+
+// decodeGeohash returns the cell covered by a geohash.
+func decodeGeohash(hash string) (BoundingBox, error) {
+	if hash == "" || len(hash) > geohashPrecision {
+		return BoundingBox{}, fmt.Errorf("geohash must be 1-%d characters", geohashPrecision)
+	}
+
+	box := BoundingBox{MinLatitude: -90, MaxLatitude: 90, MinLongitude: -180, MaxLongitude: 180}
+	even := true
+	for _, r := range hash {
+		idx := strings.IndexRune(geohashAlphabet, r)
+		if idx < 0 {
+			return BoundingBox{}, fmt.Errorf("invalid geohash character %q", r)
+		}
+		for bit := 4; bit >= 0; bit-- {
+			set := idx&(1<<bit) != 0
+			if even {
+				mid := (box.MinLongitude + box.MaxLongitude) / 2
+				if set {
+					box.MinLongitude = mid
+				} else {
+					box.MaxLongitude = mid
+				}
+			} else {
+				mid := (box.MinLatitude + box.MaxLatitude) / 2
+				if set {
+					box.MinLatitude = mid
+				} else {
+					box.MaxLatitude = mid
+				}
+			}
+			even = !even
+		}
+	}
+	return box, nil
+}
+
+// geohashCover returns geohash prefixes whose cells together cover box.
+// It picks the finest precision that needs at most maxCoverCells cells.
+func geohashCover(box BoundingBox) []string {
+	for precision := maxCoverPrecision; precision >= 1; precision-- {
+		lonBits, latBits := geohashBits(precision)
+		cellW := 360 / math.Exp2(float64(lonBits))
+		cellH := 180 / math.Exp2(float64(latBits))
+
+		lonLo, lonHi := cellIndex(box.MinLongitude+180, cellW, lonBits), cellIndex(box.MaxLongitude+180, cellW, lonBits)
+		latLo, latHi := cellIndex(box.MinLatitude+90, cellH, latBits), cellIndex(box.MaxLatitude+90, cellH, latBits)
+		if (lonHi-lonLo+1)*(latHi-latLo+1) > maxCoverCells && precision > 1 {
+			continue
+		}
+
+		var cover []string
+		for lat := latLo; lat <= latHi; lat++ {
+			for lon := lonLo; lon <= lonHi; lon++ {
+				center := Coordinate{
+					Latitude:  -90 + (float64(lat)+0.5)*cellH,
+					Longitude: -180 + (float64(lon)+0.5)*cellW,
+				}
+				cover = append(cover, encodeGeohash(center, precision))
+			}
+		}
+		return cover
+	}
+	return nil
+}
+
+// cellIndex returns the grid cell an offset falls in, clamped to the grid.
+func cellIndex(offset, size float64, bits int) int {
+	idx := int(math.Floor(offset / size))
+	if last := 1<<bits - 1; idx > last {
+		return last
+	}
+	if idx < 0 {
+		return 0
+	}
+	return idx
+}
+
+// geoEntry is a lead ID keyed by the geohash of its coordinate.
+type geoEntry struct {
+	hash string
+	id   uuid.UUID
+}
+
+// geoIndex is a sorted geohash index supporting prefix lookups.
+type geoIndex struct {
+	entries []geoEntry
+}
+
+func (idx *geoIndex) search(hash string, id uuid.UUID) int {
+	return sort.Search(len(idx.entries), func(i int) bool {
+		e := idx.entries[i]
+		if e.hash != hash {
+			return e.hash >= hash
+		}
+		return e.id.String() >= id.String()
+	})
+}
+
+// insert adds a lead to the index.
+func (idx *geoIndex) insert(c Coordinate, id uuid.UUID) {
+	hash := encodeGeohash(c, geohashPrecision)
+	i := idx.search(hash, id)
+	idx.entries = append(idx.entries, geoEntry{})
+	copy(idx.entries[i+1:], idx.entries[i:])
+	idx.entries[i] = geoEntry{hash: hash, id: id}
+}
+
+// remove deletes a lead from the index.
+func (idx *geoIndex) remove(c Coordinate, id uuid.UUID) {
+	hash := encodeGeohash(c, geohashPrecision)
+	i := idx.search(hash, id)
+	if i < len(idx.entries) && idx.entries[i].hash == hash && idx.entries[i].id == id {
+		idx.entries = append(idx.entries[:i], idx.entries[i+1:]...)
+	}
+}
+
+// prefix returns the IDs of all leads whose geohash starts with p.
+func (idx *geoIndex) prefix(p string) []uuid.UUID {
+	i := sort.Search(len(idx.entries), func(i int) bool {
+		return idx.entries[i].hash >= p
+	})
+
+	var ids []uuid.UUID
+	for ; i < len(idx.entries) && strings.HasPrefix(idx.entries[i].hash, p); i++ {
+		ids = append(ids, idx.entries[i].id)
+	}
+	return ids
+}
+
+// LeadQuery is a geospatial filter over leads. Exactly one of Radius, Box or
+// Geohash selects the area; Origin is the point distances are measured from.
+type LeadQuery struct {
+	Origin  Coordinate
+	Radius  float64
+	Box     *BoundingBox
+	Geohash string
+}
+
+// parseLeadQuery reads a geospatial query from /leads query parameters.
+// It returns nil when the request does not ask for one.
+func parseLeadQuery(values url.Values) (*LeadQuery, error) {
+	lat, lon := values.Get("lat"), values.Get("lon")
+	radius, bbox, geohash := values.Get("radius"), values.Get("bbox"), strings.ToLower(values.Get("geohash"))
+
+	selectors := 0
+	for _, v := range []string{radius, bbox, geohash} {
+		if v != "" {
+			selectors++
+		}
+	}
+	if selectors == 0 {
+		return nil, nil
+	}
+	if selectors > 1 {
+		return nil, errors.New("use only one of radius, bbox or geohash")
+	}
+
+	q := &LeadQuery{}
+	hasOrigin := lat != "" || lon != ""
+	if hasOrigin {
+		latitude, err := parseFiniteFloat(lat)
+		if err != nil {
+			return nil, fmt.Errorf("invalid lat %q", lat)
+		}
+		longitude, err := parseFiniteFloat(lon)
+		if err != nil {
+			return nil, fmt.Errorf("invalid lon %q", lon)
+		}
+		q.Origin = Coordinate{Latitude: latitude, Longitude: longitude}
+		if err := validateCoordinate(q.Origin); err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case radius != "":
+		if !hasOrigin {
+			return nil, errors.New("radius requires lat and lon")
+		}
+		r, err := parseFiniteFloat(radius)
+		if err != nil || r <= 0 || r > maxRadiusMeters {
+			return nil, fmt.Errorf("invalid radius %q: must be meters between 0 and %.0f", radius, maxRadiusMeters)
+		}
+		q.Radius = r
+
+	case bbox != "":
+		box, err := parseBoundingBox(bbox)
+		if err != nil {
+			return nil, err
+		}
+		q.Box = &box
+		if !hasOrigin {
+			q.Origin = box.Center()
+		}
+
+	case geohash != "":
+		cell, err := decodeGeohash(geohash)
+		if err != nil {
+			return nil, err
+		}
+		q.Geohash = geohash
+		if !hasOrigin {
+			q.Origin = cell.Center()
+		}
+	}
+	return q, nil
+}
@@ -0,0 +1,141 @@
+package api
+
+import (
+	"math"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestEncodeGeohash(t *testing.T) {
+	got := encodeGeohash(Coordinate{Latitude: 42.605, Longitude: -5.603}, 5)
+	if got != "ezs42" {
+		t.Errorf("encodeGeohash = %q, want ezs42", got)
+	}
+}
+
+func TestDecodeGeohashContainsEncodedPoint(t *testing.T) {
+	points := []Coordinate{
+		{Latitude: 33.98686, Longitude: -118.47542},
+		{Latitude: -33.8688, Longitude: 151.2093},
+		{Latitude: 89.9, Longitude: 179.9},
+		{Latitude: -90, Longitude: -180},
+	}
+	for _, c := range points {
+		for precision := 1; precision <= geohashPrecision; precision++ {
+			hash := encodeGeohash(c, precision)
+			cell, err := decodeGeohash(hash)
+			if err != nil {
+				t.Fatalf("decodeGeohash(%q): %v", hash, err)
+			}
+			if !cell.Contains(c) {
+				t.Errorf("cell of %q = %+v does not contain %+v", hash, cell, c)
+			}
+		}
+	}
+}
+
+func TestDecodeGeohashRejectsInvalid(t *testing.T) {
+	for _, hash := range []string{"", "ezs4a", strings.Repeat("0", geohashPrecision+1)} {
+		if _, err := decodeGeohash(hash); err == nil {
+			t.Errorf("decodeGeohash(%q) succeeded, want error", hash)
+		}
+	}
+}
+
+func TestGeohashCoverContainsBox(t *testing.T) {
+	box := BoundingBox{MinLatitude: 33.9, MinLongitude: -118.6, MaxLatitude: 34.1, MaxLongitude: -118.3}
+	cover := geohashCover(box)
+	if len(cover) == 0 || len(cover) > maxCoverCells {
+		t.Fatalf("cover has %d cells, want 1-%d", len(cover), maxCoverCells)
+	}
+
+	for _, c := range []Coordinate{
+		{Latitude: 33.9, Longitude: -118.6},
+		{Latitude: 34.1, Longitude: -118.3},
+		box.Center(),
+	} {
+		hash := encodeGeohash(c, geohashPrecision)
+		found := false
+		for _, prefix := range cover {
+			if strings.HasPrefix(hash, prefix) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("no cover cell for %+v (%s) in %v", c, hash, cover)
+		}
+	}
+}
+
+func TestBoundingBoxesAroundWrapsAntimeridian(t *testing.T) {
+	boxes := boundingBoxesAround(Coordinate{Latitude: 0, Longitude: 179.99}, 10000)
+	if len(boxes) != 2 {
+		t.Fatalf("got %d boxes, want 2: %+v", len(boxes), boxes)
+	}
+	east := Coordinate{Latitude: 0, Longitude: -179.99}
+	if !boxes[0].Contains(east) && !boxes[1].Contains(east) {
+		t.Errorf("boxes %+v miss %+v across the antimeridian", boxes, east)
+	}
+	for _, box := range boxes {
+		if box.MinLongitude < -180 || box.MaxLongitude > 180 {
+			t.Errorf("box %+v is out of range", box)
+		}
+	}
+
+	if boxes := boundingBoxesAround(Coordinate{Latitude: 10, Longitude: 10}, 10000); len(boxes) != 1 {
+		t.Errorf("got %d boxes away from the antimeridian, want 1", len(boxes))
+	}
+}
+
+func TestQueryRadiusAcrossAntimeridian(t *testing.T) {
+	s := &LeadStore{leads: make(map[uuid.UUID]Lead)}
+	near := Lead{ID: uuid.New(), Name: "near", Coordinate: Coordinate{Latitude: 0, Longitude: -179.99}}
+	far := Lead{ID: uuid.New(), Name: "far", Coordinate: Coordinate{Latitude: 0, Longitude: -170}}
+	s.put(near)
+	s.put(far)
+
+	results := s.Query(LeadQuery{Origin: Coordinate{Latitude: 0, Longitude: 179.99}, Radius: 10000})
+	if len(results) != 1 || results[0].ID != near.ID {
+		t.Fatalf("Query = %+v, want only %s", results, near.ID)
+	}
+	if results[0].Distance > 10000 {
+		t.Errorf("distance = %f, want at most 10000", results[0].Distance)
+	}
+}
+
+func TestParseLeadQueryRejectsNonFinite(t *testing.T) {
+	for _, query := range []string{
+		"lat=NaN&lon=0&radius=100",
+		"lat=0&lon=NaN&radius=100",
+		"lat=Inf&lon=0&radius=100",
+		"lat=0&lon=-Inf&radius=100",
+		"lat=0&lon=0&radius=NaN",
+		"lat=0&lon=0&radius=Inf",
+		"bbox=NaN,0,1,1",
+		"bbox=0,0,Inf,1",
+	} {
+		values, _ := url.ParseQuery(query)
+		if _, err := parseLeadQuery(values); err == nil {
+			t.Errorf("parseLeadQuery(%q) succeeded, want error", query)
+		}
+	}
+}
+
+func TestValidateCoordinate(t *testing.T) {
+	if err := validateCoordinate(Coordinate{Latitude: 90, Longitude: -180}); err != nil {
+		t.Errorf("validateCoordinate at the edges: %v", err)
+	}
+	for _, c := range []Coordinate{
+		{Latitude: math.NaN(), Longitude: 0},
+		{Latitude: 0, Longitude: math.Inf(1)},
+		{Latitude: 91, Longitude: 0},
+	} {
+		if err := validateCoordinate(c); err == nil {
+			t.Errorf("validateCoordinate(%+v) succeeded, want error", c)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// historySavePeriod is how often changed history is written to
+// WS_HISTORY_FILE.
+const historySavePeriod = 30 * time.Second
+
+// wsHistory keeps recent room messages for replay on join.
+var wsHistory *History
+
+// HistoryQuery is the payload of a "join" frame: replay the room's messages
+// received at or after Since (Unix seconds), at most the last Limit of them.
+// A zero Limit replays all kept messages and a negative one none; either way
+// the hub replays no more than fit in the client's send queue.
+type HistoryQuery struct {
+	Since int64 `json:"since,omitempty"`
+	Limit int   `json:"limit,omitempty"`
+}
+
+// historyEntry is a kept frame and when it was received.
+type historyEntry struct {
+	ID    string          `json:"id"`
+	Time  time.Time       `json:"time"`
+	Frame json.RawMessage `json:"frame"`
+}
+
+// historyRoom is the kept frames of one room, oldest first.
+type historyRoom struct {
+	Entries []historyEntry `json:"entries"`
+	Updated time.Time      `json:"updated"`
+}
+
+// History keeps the last "message" and "event" frames of each room so they
+// can be replayed to clients that join. Frames carry IDs, so clients drop
+// the ones they already have after reconnecting. With a file configured it
+// is saved periodically and on Close, and reloaded on start.
+type History struct {
+	size     int
+	maxRooms int
+	path     string
+	cancel   context.CancelFunc
+	stopped  chan struct{}
+
+	mu    sync.Mutex
+	rooms map[string]*historyRoom
+	dirty bool
+}
+
+// NewHistory creates a history configured from WS_HISTORY_SIZE (frames per
+// room, 0 disables it), WS_HISTORY_ROOMS and WS_HISTORY_FILE.
+func NewHistory() (*History, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	h := &History{
+		size:     envInt("WS_HISTORY_SIZE", 50),
+		maxRooms: envInt("WS_HISTORY_ROOMS", 1000),
+		path:     os.Getenv("WS_HISTORY_FILE"),
+		cancel:   cancel,
+		stopped:  make(chan struct{}),
+		rooms:    make(map[string]*historyRoom),
+	}
+
+	if h.path == "" {
+		close(h.stopped)
+		return h, nil
+	}
+	data, err := os.ReadFile(h.path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		cancel()
+		return nil, fmt.Errorf("failed to read history file %q: %v", h.path, err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &h.rooms); err != nil {
+			cancel()
+			return nil, fmt.Errorf("failed to parse history file %q: %v", h.path, err)
+		}
+	}
+	go h.saveLoop(ctx)
+	return h, nil
+}
+
+// Close stops saving, writing any unsaved history first.
+func (h *History) Close() {
+	h.cancel()
+	<-h.stopped
+}
+
+// Record keeps frame if it is a "message" or "event" frame for room that is
+// not already kept.
+func (h *History) Record(room string, frame []byte) {
+	if h.size <= 0 {
+		return
+	}
+	var env Envelope
+	if err := json.Unmarshal(frame, &env); err != nil || env.ID == "" {
+		return
+	}
+	if env.Type != msgMessage && env.Type != msgEvent {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	r, ok := h.rooms[room]
+	if !ok {
+		h.evictRoom()
+		r = &historyRoom{}
+		h.rooms[room] = r
+	}
+	for _, entry := range r.Entries {
+		if entry.ID == env.ID {
+			return
+		}
+	}
+	r.Entries = append(r.Entries, historyEntry{ID: env.ID, Time: now, Frame: frame})
+	if len(r.Entries) > h.size {
+		r.Entries = append(r.Entries[:0:0], r.Entries[len(r.Entries)-h.size:]...)
+	}
+	r.Updated = now
+	h.dirty = true
+}
+
+// evictRoom drops the least recently updated room when the room limit is
+// reached.
+func (h *History) evictRoom() {
+	if len(h.rooms) < h.maxRooms {
+		return
+	}
+	var oldest string
+	for room, r := range h.rooms {
+		if oldest == "" || r.Updated.Before(h.rooms[oldest].Updated) {
+			oldest = room
+		}
+	}
+	delete(h.rooms, oldest)
+}
+
+// Replay returns the frames of room matching q, oldest first.
+func (h *History) Replay(room string, q HistoryQuery) [][]byte {
+	if q.Limit < 0 {
+		return nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	r, ok := h.rooms[room]
+	if !ok {
+		return nil
+	}
+	entries := r.Entries
+	if q.Since > 0 {
+		since := time.Unix(q.Since, 0)
+		first := len(entries)
+		for i, entry := range entries {
+			if !entry.Time.Before(since) {
+				first = i
+				break
+			}
+		}
+		entries = entries[first:]
+	}
+	if q.Limit > 0 && len(entries) > q.Limit {
+		entries = entries[len(entries)-q.Limit:]
+	}
+
+	frames := make([][]byte, len(entries))
+	for i, entry := range entries {
+		frames[i] = entry.Frame
+	}
+	return frames
+}
+
+// saveLoop writes changed history to the file until ctx is cancelled, then
+// writes it one last time.
+func (h *History) saveLoop(ctx context.Context) {
+	defer close(h.stopped)
+
+	ticker := time.NewTicker(historySavePeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			h.save()
+			return
+		case <-ticker.C:
+			h.save()
+		}
+	}
+}
+
+// save writes the history file if anything changed since the last save.
+func (h *History) save() {
+	h.mu.Lock()
+	if !h.dirty {
+		h.mu.Unlock()
+		return
+	}
+	data, err := json.Marshal(h.rooms)
+	h.dirty = false
+	h.mu.Unlock()
+
+	if err == nil {
+		err = h.writeFile(data)
+	}
+	if err != nil {
+		log.Printf("Failed to save history: %v", err)
+		h.mu.Lock()
+		h.dirty = true
+		h.mu.Unlock()
+	}
+}
+
+func (h *History) writeFile(data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(h.path), ".history-*.json")
+	if err != nil {
+		return fmt.Errorf("failed to create temp history file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write history file: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write history file: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), h.path); err != nil {
+		return fmt.Errorf("failed to replace history file %q: %v", h.path, err)
+	}
+	return nil
+}
@@ -0,0 +1,311 @@
+package api
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// tokenKeys signs and verifies the tokens issued by /token.
+var tokenKeys *KeySet
+
+// signingKey is one entry of a KeySet.
+type signingKey struct {
+	kid    string
+	method jwt.SigningMethod
+	sign   interface{}
+	verify interface{}
+}
+
+// KeySet holds every key tokens may be verified with, and the one new tokens
+// are signed with. Keeping retired keys in the set lets tokens issued before a
+// rotation stay valid until they expire.
+type KeySet struct {
+	keys   map[string]*signingKey
+	active *signingKey
+}
+
+// LoadKeySet builds a key set from the environment:
+//
+//	JWT_HMAC_KEYS     kid:secret,...           HS256 shared secrets
+//	JWT_ES256_KEYS    kid:pem-or-path,...      ECDSA P-256 private keys
+//	JWT_EDDSA_KEYS    kid:pem-or-path,...      Ed25519 private keys (PKCS#8)
+//	JWT_SIGNING_KID   kid used to sign new tokens (defaults to the first key)
+//
+// With no keys configured it falls back to a random HS256 key, which only
+// works for a single instance and does not survive restarts.
+func LoadKeySet() (*KeySet, error) {
+	ks := &KeySet{keys: make(map[string]*signingKey)}
+	var order []string
+
+	for _, source := range []struct {
+		env   string
+		parse func(kid, value string) (*signingKey, error)
+	}{
+		{"JWT_ES256_KEYS", parseES256Key},
+		{"JWT_EDDSA_KEYS", parseEdDSAKey},
+		{"JWT_HMAC_KEYS", parseHMACKey},
+	} {
+		for _, entry := range strings.Split(os.Getenv(source.env), ",") {
+			if strings.TrimSpace(entry) == "" {
+				continue
+			}
+			kid, value, ok := strings.Cut(strings.TrimSpace(entry), ":")
+			if !ok || kid == "" || value == "" {
+				return nil, fmt.Errorf("%s: entries must be kid:value", source.env)
+			}
+			if _, exists := ks.keys[kid]; exists {
+				return nil, fmt.Errorf("%s: duplicate kid %q", source.env, kid)
+			}
+			key, err := source.parse(kid, value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: key %q: %v", source.env, kid, err)
+			}
+			ks.keys[kid] = key
+			order = append(order, kid)
+		}
+	}
+
+	if len(order) == 0 {
+		log.Println("⚠️ No JWT keys configured, using an ephemeral HS256 key")
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		ks.keys["ephemeral"] = &signingKey{kid: "ephemeral", method: jwt.SigningMethodHS256, sign: secret, verify: secret}
+		order = append(order, "ephemeral")
+	}
+
+	kid := os.Getenv("JWT_SIGNING_KID")
+	if kid == "" {
+		kid = order[0]
+	}
+	active, ok := ks.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("JWT_SIGNING_KID %q is not a configured key", kid)
+	}
+	ks.active = active
+	return ks, nil
+}
+
+// Sign signs claims with the active key and sets the kid header.
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(ks.active.method, claims)
+	token.Header["kid"] = ks.active.kid
+	return token.SignedString(ks.active.sign)
+}
+
+// Parse verifies tokenString against the key named by its kid header and
+// decodes it into claims.
+func (ks *KeySet) Parse(tokenString string, claims jwt.Claims) error {
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := ks.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown kid %q", kid)
+		}
+		if token.Method.Alg() != key.method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method %s for kid %q", token.Method.Alg(), kid)
+		}
+		return key.verify, nil
+	})
+	return err
+}
+
+// JWK is a public key in JSON Web Key format.
+type JWK struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	X   string `json:"x"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS returns the public halves of the asymmetric keys. HMAC secrets are
+// never published.
+func (ks *KeySet) JWKS() map[string][]JWK {
+	keys := []JWK{}
+	for _, key := range ks.keys {
+		switch pub := key.verify.(type) {
+		case *ecdsa.PublicKey:
+			keys = append(keys, JWK{
+				Kty: "EC",
+				Crv: "P-256",
+				Kid: key.kid,
+				Alg: key.method.Alg(),
+				Use: "sig",
+				X:   jwt.EncodeSegment(pub.X.FillBytes(make([]byte, 32))),
+				Y:   jwt.EncodeSegment(pub.Y.FillBytes(make([]byte, 32))),
+			})
+		case ed25519.PublicKey:
+			keys = append(keys, JWK{
+				Kty: "OKP",
+				Crv: "Ed25519",
+				Kid: key.kid,
+				Alg: key.method.Alg(),
+				Use: "sig",
+				X:   jwt.EncodeSegment(pub),
+			})
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Kid < keys[j].Kid
+	})
+	return map[string][]JWK{"keys": keys}
+}
+
+// handleJWKS serves the token verification keys at /.well-known/jwks.json.
+func handleJWKS(w http.ResponseWriter, r *http.Request) {
+	sendJSONResponse(w, tokenKeys.JWKS())
+}
+
+type contextKey string
+
+const tokenClaimsKey contextKey = "tokenClaims"
+
+// requireToken rejects requests without a valid "Authorization: Bearer" token
+// and exposes its claims to next via TokenClaimsFromContext.
+func requireToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tokenString, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || tokenString == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Bearer token required", http.StatusUnauthorized)
+			return
+		}
+
+		claims, err := verifyAccessToken(tokenString)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			return
+		}
+
+		next(w, r.WithContext(context.WithValue(r.Context(), tokenClaimsKey, claims)))
+	}
+}
+
+// verifyAccessToken checks the signature, issuer, audience and revocation
+// status of an access token and returns its claims.
+func verifyAccessToken(tokenString string) (*TokenClaims, error) {
+	claims := &TokenClaims{}
+	if err := tokenKeys.Parse(tokenString, claims); err != nil {
+		return nil, err
+	}
+	if !claims.VerifyIssuer(tokenIssuer(), true) || !claims.VerifyAudience(tokenAudience(), true) {
+		return nil, errors.New("wrong issuer or audience")
+	}
+	if sessions != nil && sessions.IsRevoked(claims) {
+		return nil, errors.New("token revoked")
+	}
+	return claims, nil
+}
+
+// TokenClaimsFromContext returns the claims of the token verified by requireToken.
+func TokenClaimsFromContext(ctx context.Context) (*TokenClaims, bool) {
+	claims, ok := ctx.Value(tokenClaimsKey).(*TokenClaims)
+	return claims, ok
+}
+
+func parseHMACKey(kid, secret string) (*signingKey, error) {
+	if len(secret) < 32 {
+		return nil, errors.New("HMAC secrets must be at least 32 bytes")
+	}
+	return &signingKey{kid: kid, method: jwt.SigningMethodHS256, sign: []byte(secret), verify: []byte(secret)}, nil
+}
+
+func parseES256Key(kid, value string) (*signingKey, error) {
+	data, err := readPEM(value)
+	if err != nil {
+		return nil, err
+	}
+	key, err := jwt.ParseECPrivateKeyFromPEM(data)
+	if err != nil {
+		return nil, err
+	}
+	if key.Curve.Params().Name != "P-256" {
+		return nil, errors.New("ES256 keys must use the P-256 curve")
+	}
+	return &signingKey{kid: kid, method: jwt.SigningMethodES256, sign: key, verify: &key.PublicKey}, nil
+}
+
+func parseEdDSAKey(kid, value string) (*signingKey, error) {
+	data, err := readPEM(value)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("invalid PEM")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.New("not an Ed25519 key")
+	}
+	return &signingKey{kid: kid, method: signingMethodEdDSA, sign: key, verify: key.Public()}, nil
+}
+
+// readPEM accepts either inline PEM or a path to a PEM file.
+func readPEM(value string) ([]byte, error) {
+	if strings.HasPrefix(value, "-----BEGIN") {
+		return []byte(strings.ReplaceAll(value, `\n`, "\n")), nil
+	}
+	return os.ReadFile(value)
+}
+
+// signingMethodEdDSA implements the EdDSA (Ed25519) JWS algorithm, which
+// jwt-go v3 does not ship.
+var signingMethodEdDSA = &signingMethodEd25519{}
+
+type signingMethodEd25519 struct{}
+
+func init() {
+	jwt.RegisterSigningMethod("EdDSA", func() jwt.SigningMethod {
+		return signingMethodEdDSA
+	})
+}
+
+func (m *signingMethodEd25519) Alg() string {
+	return "EdDSA"
+}
+
+func (m *signingMethodEd25519) Verify(signingString, signature string, key interface{}) error {
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return jwt.ErrInvalidKeyType
+	}
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(pub, []byte(signingString), sig) {
+		return jwt.ErrSignatureInvalid
+	}
+	return nil
+}
+
+func (m *signingMethodEd25519) Sign(signingString string, key interface{}) (string, error) {
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+	return jwt.EncodeSegment(ed25519.Sign(priv, []byte(signingString))), nil
+}
@@ -0,0 +1,366 @@
+package api
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// ErrLeadNotFound is returned when a lead does not exist in the store.
+var ErrLeadNotFound = errors.New("lead not found")
+
+// ErrLeadForbidden is returned when the caller may not modify a lead.
+var ErrLeadForbidden = errors.New("lead belongs to another pubkey")
+
+// errInvalidLead wraps validation failures so handlers can answer with 400.
+var errInvalidLead = errors.New("invalid lead")
+
+// defaultLeads seeds an empty store so the map always has the public chat.
+var defaultLeads = []Lead{
+	{
+		ID:   leadIDForChannel("92ef3ac79a8772ddf16a2e74e239a67bc95caebdb5bd59191c95cf91685dfc8e"),
+		Name: "Public Chat",
+		Icon: "📡",
+		Coordinate: Coordinate{
+			Latitude:  33.98686098062241,
+			Longitude: -118.4754199190118,
+		},
+		ChannelID: "92ef3ac79a8772ddf16a2e74e239a67bc95caebdb5bd59191c95cf91685dfc8e",
+	},
+}
+
+// LeadStore keeps leads in memory and persists them to a JSON file.
+type LeadStore struct {
+	mu    sync.RWMutex
+	path  string
+	leads map[uuid.UUID]Lead
+	index geoIndex
+}
+
+// NewLeadStore loads leads from path, seeding the defaults if the file does not exist yet.
+func NewLeadStore(path string) (*LeadStore, error) {
+	s := &LeadStore{
+		path:  path,
+		leads: make(map[uuid.UUID]Lead),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		for _, lead := range defaultLeads {
+			s.put(lead)
+		}
+		return s, s.save()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read leads file %q: %v", path, err)
+	}
+
+	var stored []Lead
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("failed to parse leads file %q: %v", path, err)
+	}
+	for _, lead := range stored {
+		s.put(lead)
+	}
+	return s, nil
+}
+
+// List returns all leads ordered by name.
+func (s *LeadStore) List() []Lead {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.sorted()
+}
+
+// Get returns the lead with the given ID.
+func (s *LeadStore) Get(id uuid.UUID) (Lead, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	lead, ok := s.leads[id]
+	if !ok {
+		return Lead{}, ErrLeadNotFound
+	}
+	return lead, nil
+}
+
+// Create validates and stores a new lead under a freshly generated ID.
+func (s *LeadStore) Create(lead Lead) (Lead, error) {
+	if err := validateLead(lead); err != nil {
+		return Lead{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lead.ID = uuid.New()
+	s.put(lead)
+	if err := s.save(); err != nil {
+		s.remove(lead.ID)
+		return Lead{}, err
+	}
+	return lead, nil
+}
+
+// Update replaces the lead with the given ID.
+func (s *LeadStore) Update(id uuid.UUID, lead Lead) (Lead, error) {
+	if err := validateLead(lead); err != nil {
+		return Lead{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous, ok := s.leads[id]
+	if !ok {
+		return Lead{}, ErrLeadNotFound
+	}
+
+	lead.ID = id
+	s.put(lead)
+	if err := s.save(); err != nil {
+		s.put(previous)
+		return Lead{}, err
+	}
+	return lead, nil
+}
+
+// Upsert validates and stores a lead under its own ID, creating or replacing it.
+func (s *LeadStore) Upsert(lead Lead) (Lead, error) {
+	if err := validateLead(lead); err != nil {
+		return Lead{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous, existed := s.leads[lead.ID]
+	s.put(lead)
+	if err := s.save(); err != nil {
+		if existed {
+			s.put(previous)
+		} else {
+			s.remove(lead.ID)
+		}
+		return Lead{}, err
+	}
+	return lead, nil
+}
+
+// FindByChannel returns the lead pointing at the given channel, preferring
+// the one derived from the channel itself.
+func (s *LeadStore) FindByChannel(channelID string) (Lead, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if lead, ok := s.leads[leadIDForChannel(channelID)]; ok {
+		return lead, nil
+	}
+	for _, lead := range s.sorted() {
+		if lead.ChannelID == channelID {
+			return lead, nil
+		}
+	}
+	return Lead{}, ErrLeadNotFound
+}
+
+// Delete removes the lead with the given ID.
+func (s *LeadStore) Delete(id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous, ok := s.leads[id]
+	if !ok {
+		return ErrLeadNotFound
+	}
+
+	s.remove(id)
+	if err := s.save(); err != nil {
+		s.put(previous)
+		return err
+	}
+	return nil
+}
+
+// Query returns the leads matching a geospatial query, nearest to its origin first.
+func (s *LeadStore) Query(q LeadQuery) []LeadResult {
+	var prefixes []string
+	switch {
+	case q.Geohash != "":
+		prefixes = []string{q.Geohash}
+	case q.Box != nil:
+		prefixes = geohashCover(*q.Box)
+	default:
+		for _, box := range boundingBoxesAround(q.Origin, q.Radius) {
+			prefixes = append(prefixes, geohashCover(box)...)
+		}
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	seen := make(map[uuid.UUID]bool)
+	results := []LeadResult{}
+	for _, prefix := range prefixes {
+		for _, id := range s.index.prefix(prefix) {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+
+			lead := s.leads[id]
+			if q.Box != nil && !q.Box.Contains(lead.Coordinate) {
+				continue
+			}
+			distance := distanceMeters(q.Origin, lead.Coordinate)
+			if q.Radius > 0 && distance > q.Radius {
+				continue
+			}
+			results = append(results, LeadResult{Lead: lead, Distance: distance})
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Distance < results[j].Distance
+	})
+	return results
+}
+
+// put stores a lead and indexes its coordinate. Callers must hold the write lock.
+func (s *LeadStore) put(lead Lead) {
+	s.remove(lead.ID)
+	s.leads[lead.ID] = lead
+	s.index.insert(lead.Coordinate, lead.ID)
+}
+
+// remove drops a lead and its index entry. Callers must hold the write lock.
+func (s *LeadStore) remove(id uuid.UUID) {
+	if previous, ok := s.leads[id]; ok {
+		s.index.remove(previous.Coordinate, id)
+		delete(s.leads, id)
+	}
+}
+
+// sorted returns the leads ordered by name, then ID. Callers must hold the lock.
+func (s *LeadStore) sorted() []Lead {
+	list := make([]Lead, 0, len(s.leads))
+	for _, lead := range s.leads {
+		list = append(list, lead)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Name != list[j].Name {
+			return list[i].Name < list[j].Name
+		}
+		return list[i].ID.String() < list[j].ID.String()
+	})
+	return list
+}
+
+// save writes the leads to disk atomically. Callers must hold the write lock.
+func (s *LeadStore) save() error {
+	data, err := json.MarshalIndent(s.sorted(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode leads: %v", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".leads-*.json")
+	if err != nil {
+		return fmt.Errorf("failed to create temp leads file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write leads file: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write leads file: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to replace leads file %q: %v", s.path, err)
+	}
+	return nil
+}
+
+// validateLead checks that a lead has a name, a valid coordinate and a channel event ID.
+func validateLead(lead Lead) error {
+	if strings.TrimSpace(lead.Name) == "" {
+		return fmt.Errorf("%w: name is required", errInvalidLead)
+	}
+	if err := validateCoordinate(lead.Coordinate); err != nil {
+		return fmt.Errorf("%w: %v", errInvalidLead, err)
+	}
+	if !isValidChannelID(lead.ChannelID) {
+		return fmt.Errorf("%w: channelId %q is not a 64 character hex event id", errInvalidLead, lead.ChannelID)
+	}
+	return nil
+}
+
+// validateCoordinate checks that latitude and longitude are finite and within range.
+func validateCoordinate(c Coordinate) error {
+	if math.IsNaN(c.Latitude) || math.IsNaN(c.Longitude) {
+		return errors.New("invalid coordinate: NaN is not a number")
+	}
+	if c.Latitude < -90 || c.Latitude > 90 {
+		return fmt.Errorf("invalid latitude %v: must be between -90 and 90", c.Latitude)
+	}
+	if c.Longitude < -180 || c.Longitude > 180 {
+		return fmt.Errorf("invalid longitude %v: must be between -180 and 180", c.Longitude)
+	}
+	return nil
+}
+
+// isValidChannelID reports whether id looks like a nostr event ID (32 bytes, lowercase hex).
+func isValidChannelID(id string) bool {
+	return isLowerHex32(id)
+}
+
+// isValidPubkey reports whether pubkey looks like a nostr public key (32 bytes, lowercase hex).
+func isValidPubkey(pubkey string) bool {
+	return isLowerHex32(pubkey)
+}
+
+func isLowerHex32(s string) bool {
+	if len(s) != 64 || strings.ToLower(s) != s {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// canModifyLead reports whether pubkey may update or delete lead: its creator
+// and the pubkeys listed in HUB_ADMIN_PUBKEYS can.
+func canModifyLead(lead Lead, pubkey string) bool {
+	if pubkey == "" {
+		return false
+	}
+	return lead.Pubkey == pubkey || isAdmin(pubkey)
+}
+
+// isAdmin reports whether pubkey is listed in HUB_ADMIN_PUBKEYS.
+func isAdmin(pubkey string) bool {
+	if pubkey == "" {
+		return false
+	}
+	for _, admin := range strings.Split(os.Getenv("HUB_ADMIN_PUBKEYS"), ",") {
+		if strings.TrimSpace(admin) == pubkey {
+			return true
+		}
+	}
+	return false
+}
+
+// leadIDForChannel derives a stable lead ID from a channel event ID.
+func leadIDForChannel(channelID string) uuid.UUID {
+	return uuid.NewSHA1(uuid.NameSpaceURL, []byte("nostr:"+channelID))
+}
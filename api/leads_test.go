@@ -0,0 +1,139 @@
+package api
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func newTestLead(name string) Lead {
+	return Lead{
+		Name:       name,
+		Icon:       "🛹",
+		Coordinate: Coordinate{Latitude: 34.0, Longitude: -118.4},
+		ChannelID:  strings.Repeat("a", 64),
+	}
+}
+
+func TestLeadStoreSeedsDefaults(t *testing.T) {
+	store, err := NewLeadStore(filepath.Join(t.TempDir(), "leads.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	leads := store.List()
+	if len(leads) != len(defaultLeads) || leads[0].ChannelID != defaultLeads[0].ChannelID {
+		t.Errorf("new store has %+v, want the defaults", leads)
+	}
+}
+
+func TestLeadStorePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leads.json")
+	store, err := NewLeadStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	created, err := store.Create(newTestLead("Venice"))
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if created.ID == uuid.Nil {
+		t.Fatal("Create did not assign an ID")
+	}
+	updated := newTestLead("Venice Beach")
+	if _, err := store.Update(created.ID, updated); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	reloaded, err := NewLeadStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := reloaded.Get(created.ID)
+	if err != nil {
+		t.Fatalf("Get after reload: %v", err)
+	}
+	if got.Name != "Venice Beach" {
+		t.Errorf("reloaded lead = %+v", got)
+	}
+
+	if err := reloaded.Delete(created.ID); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := reloaded.Get(created.ID); !errors.Is(err, ErrLeadNotFound) {
+		t.Errorf("Get after Delete = %v, want ErrLeadNotFound", err)
+	}
+	if err := reloaded.Delete(created.ID); !errors.Is(err, ErrLeadNotFound) {
+		t.Errorf("second Delete = %v, want ErrLeadNotFound", err)
+	}
+	if _, err := reloaded.Update(uuid.New(), updated); !errors.Is(err, ErrLeadNotFound) {
+		t.Errorf("Update of a missing lead = %v, want ErrLeadNotFound", err)
+	}
+}
+
+func TestLeadStoreValidates(t *testing.T) {
+	store, err := NewLeadStore(filepath.Join(t.TempDir(), "leads.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, mutate := range map[string]func(*Lead){
+		"blank name":      func(l *Lead) { l.Name = " " },
+		"latitude":        func(l *Lead) { l.Coordinate.Latitude = 91 },
+		"longitude":       func(l *Lead) { l.Coordinate.Longitude = -181 },
+		"short channel":   func(l *Lead) { l.ChannelID = "abc" },
+		"upper channel":   func(l *Lead) { l.ChannelID = strings.Repeat("A", 64) },
+		"non-hex channel": func(l *Lead) { l.ChannelID = strings.Repeat("g", 64) },
+	} {
+		lead := newTestLead("Venice")
+		mutate(&lead)
+		if _, err := store.Create(lead); !errors.Is(err, errInvalidLead) {
+			t.Errorf("%s: Create = %v, want errInvalidLead", name, err)
+		}
+	}
+}
+
+func TestLeadStoreFindByChannel(t *testing.T) {
+	store, err := NewLeadStore(filepath.Join(t.TempDir(), "leads.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	channel := strings.Repeat("a", 64)
+	if _, err := store.Create(newTestLead("Alpha")); err != nil {
+		t.Fatal(err)
+	}
+	derived := newTestLead("Zulu")
+	derived.ID = leadIDForChannel(channel)
+	if _, err := store.Upsert(derived); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := store.FindByChannel(channel)
+	if err != nil || got.ID != derived.ID {
+		t.Errorf("FindByChannel = %+v, %v, want the lead derived from the channel", got, err)
+	}
+	if _, err := store.FindByChannel(strings.Repeat("b", 64)); !errors.Is(err, ErrLeadNotFound) {
+		t.Errorf("FindByChannel of an unknown channel = %v", err)
+	}
+}
+
+func TestCanModifyLead(t *testing.T) {
+	t.Setenv("HUB_ADMIN_PUBKEYS", "admin, other")
+	lead := Lead{Pubkey: "owner"}
+	for pubkey, want := range map[string]bool{
+		"owner":    true,
+		"admin":    true,
+		"other":    true,
+		"stranger": false,
+		"":         false,
+	} {
+		if got := canModifyLead(lead, pubkey); got != want {
+			t.Errorf("canModifyLead(%q) = %v, want %v", pubkey, got, want)
+		}
+	}
+	if canModifyLead(Lead{}, "") {
+		t.Error("an anonymous caller may modify an unowned lead")
+	}
+}
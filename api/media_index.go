@@ -0,0 +1,256 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"hub/storage"
+)
+
+// mediaIndex maps channels to their objects in blob storage.
+var mediaIndex *MediaIndex
+
+// MediaObject is a media file stored for a channel.
+type MediaObject struct {
+	Key          string    `json:"key"`
+	URL          string    `json:"url"`
+	Size         int64     `json:"size"`
+	ETag         string    `json:"etag"`
+	LastModified time.Time `json:"lastModified"`
+}
+
+// indexedObject is an object and the channel it belongs to ("" if none).
+type indexedObject struct {
+	object  MediaObject
+	channel string
+}
+
+// MediaIndex keeps a channel → objects index of a blob store. Objects are
+// assigned to a channel by their "channels/<id>/" key prefix, or for legacy
+// uploads by a "channel" object tag when the store supports tags. Refresh
+// lists the store page by page and only fetches tags for objects that are new
+// or changed since the last run. Blossom owner and ref markers are not media
+// and are skipped.
+type MediaIndex struct {
+	store       storage.BlobStore
+	concurrency int
+
+	refreshMu sync.Mutex
+
+	mu        sync.RWMutex
+	objects   map[string]indexedObject
+	byChannel map[string]map[string]bool
+	indexedAt time.Time
+}
+
+// NewMediaIndex creates an empty index over store. MEDIA_INDEX_CONCURRENCY
+// bounds the tag requests in flight, at least one.
+func NewMediaIndex(store storage.BlobStore) *MediaIndex {
+	concurrency := envInt("MEDIA_INDEX_CONCURRENCY", 16)
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &MediaIndex{
+		store:       store,
+		concurrency: concurrency,
+		objects:     make(map[string]indexedObject),
+		byChannel:   make(map[string]map[string]bool),
+	}
+}
+
+// Run refreshes the index every interval until ctx is cancelled.
+func (idx *MediaIndex) Run(ctx context.Context, interval time.Duration) {
+	for {
+		start := time.Now()
+		if err := idx.Refresh(ctx); err != nil {
+			log.Printf("Media index refresh failed: %v", err)
+		} else {
+			log.Printf("Media index refreshed in %v", time.Since(start).Round(time.Millisecond))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Refresh brings the index up to date with the bucket.
+func (idx *MediaIndex) Refresh(ctx context.Context) error {
+	idx.refreshMu.Lock()
+	defer idx.refreshMu.Unlock()
+
+	idx.mu.RLock()
+	previous := idx.objects
+	idx.mu.RUnlock()
+
+	current := make(map[string]indexedObject, len(previous))
+	var untagged []MediaObject
+
+	tagger, hasTags := idx.store.(storage.Tagger)
+	err := idx.store.List(ctx, "", func(info storage.ObjectInfo) bool {
+		if !isMediaKey(info.Key) {
+			return true
+		}
+		obj := MediaObject{
+			Key:          info.Key,
+			URL:          idx.store.URL(info.Key),
+			Size:         info.Size,
+			ETag:         info.ETag,
+			LastModified: info.LastModified,
+		}
+
+		if channel := channelFromKey(obj.Key); channel != "" || !hasTags {
+			current[obj.Key] = indexedObject{object: obj, channel: channel}
+			return true
+		}
+		if prev, ok := previous[obj.Key]; ok && prev.object.ETag == obj.ETag {
+			current[obj.Key] = indexedObject{object: obj, channel: prev.channel}
+			return true
+		}
+		untagged = append(untagged, obj)
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("unable to list media: %v", err)
+	}
+
+	tagged, err := idx.fetchChannelTags(ctx, tagger, untagged)
+	if err != nil {
+		return err
+	}
+	for _, entry := range tagged {
+		current[entry.object.Key] = entry
+	}
+
+	byChannel := make(map[string]map[string]bool)
+	for key, entry := range current {
+		if entry.channel == "" {
+			continue
+		}
+		if byChannel[entry.channel] == nil {
+			byChannel[entry.channel] = make(map[string]bool)
+		}
+		byChannel[entry.channel][key] = true
+	}
+
+	idx.mu.Lock()
+	idx.objects = current
+	idx.byChannel = byChannel
+	idx.indexedAt = time.Now()
+	idx.mu.Unlock()
+	return nil
+}
+
+// fetchChannelTags reads the "channel" tag of each object using a bounded
+// number of concurrent requests. Objects whose tags cannot be read, such as
+// ones deleted since the listing, are left out, so they have no channel and
+// are retried on the next refresh. It only fails if ctx ends.
+func (idx *MediaIndex) fetchChannelTags(ctx context.Context, tagger storage.Tagger, objects []MediaObject) ([]indexedObject, error) {
+	jobs := make(chan MediaObject)
+	results := make([]indexedObject, 0, len(objects))
+	var (
+		mu     sync.Mutex
+		failed int
+		wg     sync.WaitGroup
+	)
+
+	for i := 0; i < idx.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for obj := range jobs {
+				tags, err := tagger.Tags(ctx, obj.Key)
+
+				mu.Lock()
+				if err != nil {
+					if !errors.Is(err, storage.ErrNotFound) {
+						failed++
+					}
+				} else {
+					results = append(results, indexedObject{object: obj, channel: tags["channel"]})
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, obj := range objects {
+		if ctx.Err() != nil {
+			break
+		}
+		jobs <- obj
+	}
+	close(jobs)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if failed > 0 {
+		log.Printf("Media index could not read the tags of %d objects, retrying on the next refresh", failed)
+	}
+	return results, nil
+}
+
+// isMediaKey reports whether key may hold media, as opposed to the Blossom
+// owner and ref markers.
+func isMediaKey(key string) bool {
+	return !strings.HasPrefix(key, blossomOwnerPrefix) && !strings.HasPrefix(key, blossomRefPrefix)
+}
+
+// Channel returns the objects of a channel, newest first, and when the index
+// was last refreshed.
+func (idx *MediaIndex) Channel(channelID string) ([]MediaObject, time.Time) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	objects := []MediaObject{}
+	for key := range idx.byChannel[channelID] {
+		objects = append(objects, idx.objects[key].object)
+	}
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].LastModified.After(objects[j].LastModified)
+	})
+	return objects, idx.indexedAt
+}
+
+// channelFromKey returns the channel encoded in a "channels/<id>/..." key.
+func channelFromKey(key string) string {
+	rest, ok := strings.CutPrefix(key, "channels/")
+	if !ok {
+		return ""
+	}
+	channel, _, ok := strings.Cut(rest, "/")
+	if !ok || !isValidChannelID(channel) {
+		return ""
+	}
+	return channel
+}
+
+// handleChannelMedia handles requests to /channel/{id}/media.
+func handleChannelMedia(w http.ResponseWriter, r *http.Request, channelID string) {
+	if mediaIndex == nil {
+		http.Error(w, "Media index not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	objects, indexedAt := mediaIndex.Channel(channelID)
+	if indexedAt.IsZero() {
+		http.Error(w, "Media index is still loading", http.StatusServiceUnavailable)
+		return
+	}
+
+	sendJSONResponse(w, map[string]interface{}{
+		"media":     objects,
+		"indexedAt": indexedAt.Unix(),
+	})
+}
@@ -0,0 +1,169 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"hub/storage"
+)
+
+// taggedStore adds S3-style object tags to a LocalStore.
+type taggedStore struct {
+	*storage.LocalStore
+
+	mu    sync.Mutex
+	tags  map[string]map[string]string
+	errs  map[string]error
+	calls int
+}
+
+func (s *taggedStore) Tags(ctx context.Context, key string) (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+	if err := s.errs[key]; err != nil {
+		return nil, err
+	}
+	return s.tags[key], nil
+}
+
+func newTaggedStore(t *testing.T) *taggedStore {
+	t.Helper()
+	local, err := storage.NewLocal(storage.Config{Dir: t.TempDir(), PublicURL: "http://hub.test/blobs"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &taggedStore{LocalStore: local, tags: make(map[string]map[string]string), errs: make(map[string]error)}
+}
+
+func putObject(t *testing.T, store storage.BlobStore, key, body string) {
+	t.Helper()
+	if err := store.Put(context.Background(), key, strings.NewReader(body), storage.PutOptions{ContentType: "video/mp4"}); err != nil {
+		t.Fatalf("Put %s: %v", key, err)
+	}
+}
+
+func TestMediaIndexRefresh(t *testing.T) {
+	t.Setenv("MEDIA_INDEX_CONCURRENCY", "0")
+	store := newTaggedStore(t)
+	channel := strings.Repeat("a", 64)
+	other := strings.Repeat("b", 64)
+
+	putObject(t, store, "channels/"+channel+"/clip.mp4", "one")
+	putObject(t, store, "legacy/old.mp4", "two")
+	putObject(t, store, "legacy/untagged.mp4", "three")
+	store.tags["legacy/old.mp4"] = map[string]string{"channel": other}
+
+	idx := NewMediaIndex(store)
+	if idx.concurrency != 1 {
+		t.Errorf("concurrency = %d, want it clamped to 1", idx.concurrency)
+	}
+	if err := idx.Refresh(context.Background()); err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+
+	objects, indexedAt := idx.Channel(channel)
+	if indexedAt.IsZero() || len(objects) != 1 || objects[0].Key != "channels/"+channel+"/clip.mp4" {
+		t.Errorf("Channel(%s) = %+v", channel, objects)
+	}
+	if objects[0].URL != "http://hub.test/blobs/channels/"+channel+"/clip.mp4" {
+		t.Errorf("URL = %q", objects[0].URL)
+	}
+	if objects, _ := idx.Channel(other); len(objects) != 1 || objects[0].Key != "legacy/old.mp4" {
+		t.Errorf("Channel(%s) = %+v, want the tagged legacy object", other, objects)
+	}
+	if store.calls != 2 {
+		t.Errorf("fetched tags %d times, want 2 for the legacy objects", store.calls)
+	}
+
+	// Unchanged objects keep their channel without refetching tags
+	store.calls = 0
+	if err := idx.Refresh(context.Background()); err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if store.calls != 0 {
+		t.Errorf("fetched tags %d times for unchanged objects, want 0", store.calls)
+	}
+
+	// A changed object is retagged
+	putObject(t, store, "legacy/old.mp4", "changed")
+	store.tags["legacy/old.mp4"] = map[string]string{"channel": channel}
+	if err := idx.Refresh(context.Background()); err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if objects, _ := idx.Channel(channel); len(objects) != 2 {
+		t.Errorf("Channel(%s) has %d objects after retagging, want 2", channel, len(objects))
+	}
+	if objects, _ := idx.Channel(other); len(objects) != 0 {
+		t.Errorf("Channel(%s) still has %+v", other, objects)
+	}
+}
+
+func TestMediaIndexWithoutTags(t *testing.T) {
+	store := newTaggedStore(t)
+	channel := strings.Repeat("c", 64)
+	putObject(t, store.LocalStore, "channels/"+channel+"/a.mp4", "a")
+	putObject(t, store.LocalStore, "loose.mp4", "b")
+
+	idx := NewMediaIndex(store.LocalStore)
+	if err := idx.Refresh(context.Background()); err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if objects, _ := idx.Channel(channel); len(objects) != 1 {
+		t.Errorf("Channel(%s) = %+v, want one object", channel, objects)
+	}
+}
+
+func TestChannelFromKey(t *testing.T) {
+	channel := strings.Repeat("d", 64)
+	for key, want := range map[string]string{
+		"channels/" + channel + "/x.mp4": channel,
+		"channels/" + channel:            "",
+		"channels/short/x.mp4":           "",
+		"uploads/" + channel + "/x.mp4":  "",
+	} {
+		if got := channelFromKey(key); got != want {
+			t.Errorf("channelFromKey(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestMediaIndexSkipsMarkersAndTagErrors(t *testing.T) {
+	store := newTaggedStore(t)
+	channel := strings.Repeat("e", 64)
+	owner := strings.Repeat("f", 64)
+	sha := strings.Repeat("0", 64)
+
+	putObject(t, store, blossomOwnerPrefix+owner+"/"+sha, "{}")
+	putObject(t, store, blossomRefPrefix+sha+"/"+owner, "")
+	putObject(t, store, "legacy/gone.mp4", "a")
+	putObject(t, store, "legacy/flaky.mp4", "b")
+	putObject(t, store, "legacy/ok.mp4", "c")
+	store.errs["legacy/gone.mp4"] = storage.ErrNotFound
+	store.errs["legacy/flaky.mp4"] = errors.New("throttled")
+	store.tags["legacy/flaky.mp4"] = map[string]string{"channel": channel}
+	store.tags["legacy/ok.mp4"] = map[string]string{"channel": channel}
+
+	idx := NewMediaIndex(store)
+	if err := idx.Refresh(context.Background()); err != nil {
+		t.Fatalf("Refresh with failing tag reads = %v, want nil", err)
+	}
+	if store.calls != 3 {
+		t.Errorf("fetched tags %d times, want 3 with the Blossom markers skipped", store.calls)
+	}
+	if objects, _ := idx.Channel(channel); len(objects) != 1 || objects[0].Key != "legacy/ok.mp4" {
+		t.Errorf("Channel(%s) = %+v, want only legacy/ok.mp4", channel, objects)
+	}
+
+	// The object whose tags failed is retried on the next refresh
+	delete(store.errs, "legacy/flaky.mp4")
+	if err := idx.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if objects, _ := idx.Channel(channel); len(objects) != 2 {
+		t.Errorf("Channel(%s) has %d objects after the retry, want 2", channel, len(objects))
+	}
+}
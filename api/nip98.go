@@ -0,0 +1,226 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const (
+	// kindHTTPAuth is the NIP-98 HTTP auth event kind.
+	kindHTTPAuth = 27235
+	// nip98MaxSkew is how far an auth event's created_at may be from now.
+	nip98MaxSkew = 60 * time.Second
+	// nip98MaxBody bounds how much of a request body is read for hashing.
+	nip98MaxBody = 1 << 20
+
+	pubkeyKey contextKey = "pubkey"
+)
+
+// seenAuthEvents remembers recently used auth event IDs so a captured
+// header cannot be replayed within the freshness window. Expired IDs are
+// dropped by sweepAuthEvents.
+var seenAuthEvents = struct {
+	sync.Mutex
+	ids map[string]time.Time
+}{ids: make(map[string]time.Time)}
+
+// requireNostrAuth rejects requests without a valid NIP-98
+// "Authorization: Nostr <base64 event>" header and exposes the caller's
+// pubkey to next via PubkeyFromContext.
+func requireNostrAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		pubkey, err := verifyHTTPAuth(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", "Nostr")
+			http.Error(w, "Unauthorized: "+err.Error(), http.StatusUnauthorized)
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), pubkeyKey, pubkey)))
+	}
+}
+
+// requireNostrAuthForWrites applies requireNostrAuth to every method except
+// GET and HEAD.
+func requireNostrAuthForWrites(next http.HandlerFunc) http.HandlerFunc {
+	authenticated := requireNostrAuth(next)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next(w, r)
+			return
+		}
+		authenticated(w, r)
+	}
+}
+
+// PubkeyFromContext returns the hex pubkey authenticated by requireNostrAuth.
+func PubkeyFromContext(ctx context.Context) (string, bool) {
+	pubkey, ok := ctx.Value(pubkeyKey).(string)
+	return pubkey, ok
+}
+
+// verifyHTTPAuth checks a NIP-98 auth event against the request and returns
+// its pubkey. If the request has a body it is read, checked against the
+// payload tag, and put back for the handler.
+func verifyHTTPAuth(r *http.Request) (string, error) {
+	encoded, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Nostr ")
+	if !ok || encoded == "" {
+		return "", errors.New("missing Nostr authorization")
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return "", errors.New("authorization is not valid base64")
+	}
+
+	var ev nostr.Event
+	if err := json.Unmarshal(raw, &ev); err != nil {
+		return "", errors.New("authorization is not a nostr event")
+	}
+	if ev.Kind != kindHTTPAuth {
+		return "", fmt.Errorf("auth event must be kind %d", kindHTTPAuth)
+	}
+	if err := verifyEvent(&ev); err != nil {
+		return "", err
+	}
+
+	skew := time.Since(ev.CreatedAt.Time())
+	if skew > nip98MaxSkew || skew < -nip98MaxSkew {
+		return "", errors.New("auth event is stale")
+	}
+
+	if tagValue(ev.Tags, "u") != requestURL(r) {
+		return "", errors.New("auth event u tag does not match the request URL")
+	}
+	if !strings.EqualFold(tagValue(ev.Tags, "method"), r.Method) {
+		return "", errors.New("auth event method tag does not match the request method")
+	}
+
+	if r.Body != nil && r.Body != http.NoBody {
+		body, err := io.ReadAll(http.MaxBytesReader(nil, r.Body, nip98MaxBody))
+		r.Body.Close()
+		if err != nil {
+			return "", errors.New("failed to read request body")
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		if len(body) > 0 {
+			sum := sha256.Sum256(body)
+			if !strings.EqualFold(tagValue(ev.Tags, "payload"), hex.EncodeToString(sum[:])) {
+				return "", errors.New("auth event payload tag does not match the request body")
+			}
+		}
+	}
+
+	if !markAuthEventUsed(ev.ID) {
+		return "", errors.New("auth event was already used")
+	}
+	return ev.PubKey, nil
+}
+
+// markAuthEventUsed records an auth event ID, reporting false if it was
+// already seen within the freshness window.
+func markAuthEventUsed(id string) bool {
+	seenAuthEvents.Lock()
+	defer seenAuthEvents.Unlock()
+
+	if _, ok := seenAuthEvents.ids[id]; ok {
+		return false
+	}
+	seenAuthEvents.ids[id] = time.Now()
+	return true
+}
+
+// sweepAuthEvents forgets auth event IDs once they are too old to pass the
+// freshness check again, every nip98MaxSkew until ctx is cancelled.
+func sweepAuthEvents(ctx context.Context) {
+	ticker := time.NewTicker(nip98MaxSkew)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			expireAuthEvents(now)
+		}
+	}
+}
+
+// expireAuthEvents drops the auth event IDs seen more than twice the
+// allowed skew before now.
+func expireAuthEvents(now time.Time) {
+	seenAuthEvents.Lock()
+	defer seenAuthEvents.Unlock()
+	for id, at := range seenAuthEvents.ids {
+		if now.Sub(at) > 2*nip98MaxSkew {
+			delete(seenAuthEvents.ids, id)
+		}
+	}
+}
+
+// requestURL reconstructs the absolute URL the client called.
+func requestURL(r *http.Request) string {
+	return publicBaseURL(r) + r.URL.RequestURI()
+}
+
+// publicBaseURL returns the scheme and host clients reach the API at.
+// PUBLIC_URL overrides them when the API sits behind a proxy that rewrites
+// them. Otherwise, with TRUST_PROXY set, the X-Forwarded-Proto and
+// X-Forwarded-Host entries added by our proxy are used; the headers are
+// ignored without it, since any client could forge them.
+func publicBaseURL(r *http.Request) string {
+	if base := os.Getenv("PUBLIC_URL"); base != "" {
+		return strings.TrimRight(base, "/")
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	host := r.Host
+	if os.Getenv("TRUST_PROXY") == "" {
+		return scheme + "://" + host
+	}
+
+	if proto := lastHeaderEntry(r, "X-Forwarded-Proto"); proto == "http" || proto == "https" {
+		scheme = proto
+	}
+	if forwarded := lastHeaderEntry(r, "X-Forwarded-Host"); forwarded != "" {
+		host = forwarded
+	}
+	return scheme + "://" + host
+}
+
+// lastHeaderEntry returns the last comma-separated entry of a header, the one
+// added by the nearest proxy.
+func lastHeaderEntry(r *http.Request, name string) string {
+	values := r.Header.Values(name)
+	if len(values) == 0 {
+		return ""
+	}
+	entries := strings.Split(values[len(values)-1], ",")
+	return strings.TrimSpace(entries[len(entries)-1])
+}
+
+// tagValue returns the value of the first tag named name.
+func tagValue(tags nostr.Tags, name string) string {
+	for _, tag := range tags {
+		if len(tag) >= 2 && tag[0] == name {
+			return tag[1]
+		}
+	}
+	return ""
+}
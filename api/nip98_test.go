@@ -0,0 +1,53 @@
+package api
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPublicBaseURL(t *testing.T) {
+	r := httptest.NewRequest("GET", "http://api.internal/leads", nil)
+	r.Header.Set("X-Forwarded-Proto", "https")
+	r.Header.Set("X-Forwarded-Host", "evil.example, hub.example")
+
+	t.Setenv("PUBLIC_URL", "")
+	t.Setenv("TRUST_PROXY", "")
+	if got := publicBaseURL(r); got != "http://api.internal" {
+		t.Errorf("untrusted proxy: publicBaseURL = %q, want http://api.internal", got)
+	}
+
+	t.Setenv("TRUST_PROXY", "1")
+	if got := publicBaseURL(r); got != "https://hub.example" {
+		t.Errorf("trusted proxy: publicBaseURL = %q, want https://hub.example", got)
+	}
+
+	r.Header.Set("X-Forwarded-Proto", "javascript")
+	if got := publicBaseURL(r); got != "http://hub.example" {
+		t.Errorf("bad proto: publicBaseURL = %q, want http://hub.example", got)
+	}
+
+	t.Setenv("PUBLIC_URL", "https://api.skatepark.chat/")
+	if got := publicBaseURL(r); got != "https://api.skatepark.chat" {
+		t.Errorf("PUBLIC_URL: publicBaseURL = %q, want https://api.skatepark.chat", got)
+	}
+}
+
+func TestMarkAuthEventUsed(t *testing.T) {
+	id := "nip98-test-event"
+	if !markAuthEventUsed(id) {
+		t.Fatal("first use rejected")
+	}
+	if markAuthEventUsed(id) {
+		t.Error("replay accepted")
+	}
+
+	expireAuthEvents(time.Now())
+	if markAuthEventUsed(id) {
+		t.Error("replay accepted after sweeping fresh IDs")
+	}
+	expireAuthEvents(time.Now().Add(2*nip98MaxSkew + time.Second))
+	if !markAuthEventUsed(id) {
+		t.Error("expired ID still remembered after the sweep")
+	}
+}
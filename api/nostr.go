@@ -2,144 +2,175 @@ package api
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/nbd-wtf/go-nostr"
 )
 
-type RelayHelper struct {
-	url        string
-	ctx        context.Context
-	maxRetries int
-	delay      time.Duration
+// relays is the connection pool shared by all relay reads.
+var relays *RelayPool
+
+// fetchRelay reads the stored events matching a filter from one relay. It is
+// fetchFromRelay, replaced in tests.
+var fetchRelay = fetchFromRelay
+
+// RelayReport records which relays answered a fetch.
+type RelayReport struct {
+	Answered []string          `json:"answered"`
+	Failed   map[string]string `json:"failed,omitempty"`
 }
 
-func NewRelayHelper(url string, maxRetries int, delay time.Duration) *RelayHelper {
-	return &RelayHelper{
-		url:        url,
-		ctx:        context.Background(),
-		maxRetries: maxRetries,
-		delay:      delay,
-	}
+// FetchResult is the merged outcome of querying the relay set.
+type FetchResult struct {
+	Events []nostr.Event
+	Relays RelayReport
 }
 
-// Connect to the relay and return the subscription
-func (r *RelayHelper) ConnectAndSubscribe(filters []nostr.Filter) (*nostr.Subscription, *nostr.Relay, error) {
-	var relay *nostr.Relay
-	var sub *nostr.Subscription
-	var err error
-
-	// Retry logic for reconnection
-	for retryCount := 0; retryCount < r.maxRetries; retryCount++ {
-		relay, err = nostr.RelayConnect(r.ctx, r.url)
-		if err != nil {
-			log.Printf("Failed to connect to relay: %v. Retrying in %v... (Attempt %d/%d)\n", err, r.delay, retryCount+1, r.maxRetries)
-			time.Sleep(r.delay)
-			continue
+// relayURLs returns the configured relay set: HUB_RELAYS (comma separated),
+// falling back to HUB_RELAY.
+func relayURLs() []string {
+	var urls []string
+	for _, url := range strings.Split(os.Getenv("HUB_RELAYS"), ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			urls = append(urls, url)
 		}
+	}
+	if len(urls) == 0 {
+		urls = []string{os.Getenv("HUB_RELAY")}
+	}
+	return urls
+}
 
-		// Subscribe to the relay with the given filters
-		sub, err = relay.Subscribe(r.ctx, filters)
-		if err != nil {
-			log.Printf("Failed to subscribe to relay: %v", err)
-			time.Sleep(r.delay)
-			continue
+// FetchEvents queries every configured relay in parallel and returns the
+// stored events matching filter, de-duplicated by ID. Each relay gets
+// RELAY_TIMEOUT to reach EOSE; events received before a timeout are kept.
+// It only fails when no relay answered at all.
+func FetchEvents(ctx context.Context, filter nostr.Filter) (FetchResult, error) {
+	urls := relayURLs()
+	timeout := envDuration("RELAY_TIMEOUT", 5*time.Second)
+
+	type relayResult struct {
+		url    string
+		events []nostr.Event
+		err    error
+	}
+	results := make(chan relayResult, len(urls))
+	for _, url := range urls {
+		go func(url string) {
+			relayCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			events, err := fetchRelay(relayCtx, url, filter)
+			results <- relayResult{url: url, events: events, err: err}
+		}(url)
+	}
+
+	result := FetchResult{Relays: RelayReport{Answered: []string{}}}
+	seen := make(map[string]bool)
+	for range urls {
+		r := <-results
+		if r.err != nil {
+			if result.Relays.Failed == nil {
+				result.Relays.Failed = make(map[string]string)
+			}
+			result.Relays.Failed[r.url] = r.err.Error()
+			log.Printf("Relay %s failed: %v", r.url, r.err)
+		} else {
+			result.Relays.Answered = append(result.Relays.Answered, r.url)
 		}
 
-		// Successful connection and subscription
-		return sub, relay, nil
+		for _, ev := range r.events {
+			if !seen[ev.ID] {
+				seen[ev.ID] = true
+				result.Events = append(result.Events, ev)
+			}
+		}
 	}
+	sort.Strings(result.Relays.Answered)
 
-	// Max retries reached
-	return nil, nil, fmt.Errorf("max retries reached. could not connect to the relay")
+	if len(result.Relays.Answered) == 0 && len(result.Events) == 0 {
+		return result, fmt.Errorf("no relay answered (%d failed)", len(urls))
+	}
+	return result, nil
 }
 
-func FetchEvents(nsec string, npub string, channelId string, tags map[string][]string) ([]nostr.Event, error) {
-	url := os.Getenv("HUB_RELAY")
-
-	helper := NewRelayHelper(url, 5, 5*time.Second) // RelayHelper with retry logic
-
-	// Define filters
-	filters := []nostr.Filter{{
-		Kinds: []int{nostr.KindChannelMessage},
-		Tags:  tags,
-		Limit: 64,
-	}}
-
-	sub, relay, err := helper.ConnectAndSubscribe(filters)
+// fetchFromRelay returns the stored events matching filter from a single
+// relay, stopping at EOSE.
+func fetchFromRelay(ctx context.Context, url string, filter nostr.Filter) ([]nostr.Event, error) {
+	sub, err := relays.Subscribe(ctx, url, nostr.Filters{filter})
 	if err != nil {
 		return nil, err
 	}
+	defer sub.Unsub()
 
 	var events []nostr.Event
-	processingStoredEvents := true
-
 	for {
 		select {
-		case event := <-sub.Events:
-			// Dereference and append the event
+		case event, ok := <-sub.Events:
+			if !ok {
+				// Subscription closed, meaning the connection dropped
+				return events, errors.New("connection closed before end of stored events")
+			}
 			events = append(events, *event)
 
 		case <-sub.EndOfStoredEvents:
-			// All cached events have been received, stop processing
-			processingStoredEvents = false
-			fmt.Println("End of stored events received, closing connection...")
-			relay.Close()
+			// All stored events have been received
 			return events, nil
 
-		case <-relay.Context().Done():
-			// Relay context is done, meaning the connection is closed
-			fmt.Println("Relay context done, closing connection...")
-			relay.Close()
-			return events, nil
-		}
-
-		if !processingStoredEvents {
-			break
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return events, errors.New("timed out waiting for end of stored events")
+			}
+			return events, ctx.Err()
 		}
 	}
-
-	// Return fetched events (in case no EndOfStoredEvents signal is received)
-	return events, nil
 }
 
-// handleChannelVideos handles HTTP requests to fetch videos for a specific channel
+// handleChannelVideos handles HTTP requests to fetch videos for a specific channel.
+// It accepts since, until, limit and cursor query parameters and returns events
+// newest first with a cursor for the next (older) page.
 func handleChannelVideos(w http.ResponseWriter, r *http.Request) {
 	// Extract channelId from the URL path (e.g., /channel/{channelId})
 	path := r.URL.Path
 	parts := strings.Split(path, "/")
-	if len(parts) < 3 {
+	if len(parts) < 3 || !isValidChannelID(parts[2]) {
 		http.Error(w, "Invalid URL path", http.StatusBadRequest)
 		return
 	}
 	channelId := parts[2]
 
-	// Get the nsec and npub values from environment variables or configuration
-	nsec := os.Getenv("HUB_NSEC")
-	npub := os.Getenv("HUB_NPUB")
+	if len(parts) > 3 && parts[3] == "media" {
+		handleChannelMedia(w, r, channelId)
+		return
+	}
 
-	// Define the tags for video events
-	tags := make(map[string][]string)
-	tags["e"] = []string{channelId}
-	// tags["t"] = []string{"video"}
+	page, err := parseChannelPage(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	// Fetch video events using the generic FetchEvents function
-	videoEvents, err := FetchEvents(nsec, npub, channelId, tags)
+	// Serve from the channel cache, falling back to the relays
+	response, hit, err := channelCache.Page(r.Context(), channelId, page)
 	if err != nil {
 		log.Printf("Failed to fetch videos for channelId=%s: %v", channelId, err)
 		http.Error(w, "Failed to retrieve videos", http.StatusInternalServerError)
 		return
 	}
 
-	// Convert the video events to JSON
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string][]nostr.Event{
-		"videos": videoEvents,
-	})
+	cacheStatus := "MISS"
+	if hit {
+		cacheStatus = "HIT"
+	}
+	log.Printf("Channel cache %s for channelId=%s", cacheStatus, channelId)
+	w.Header().Set("X-Cache", cacheStatus)
+
+	sendJSONResponse(w, response)
 }
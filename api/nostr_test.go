@@ -0,0 +1,86 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// stubRelays makes FetchEvents read from canned per-relay results.
+func stubRelays(t *testing.T, results map[string]func() ([]nostr.Event, error)) {
+	t.Helper()
+	var urls []string
+	for url := range results {
+		urls = append(urls, url)
+	}
+	t.Setenv("HUB_RELAYS", strings.Join(urls, ","))
+
+	previous := fetchRelay
+	fetchRelay = func(ctx context.Context, url string, filter nostr.Filter) ([]nostr.Event, error) {
+		return results[url]()
+	}
+	t.Cleanup(func() { fetchRelay = previous })
+}
+
+func TestFetchEventsMergesRelays(t *testing.T) {
+	stubRelays(t, map[string]func() ([]nostr.Event, error){
+		"wss://a.test": func() ([]nostr.Event, error) {
+			return []nostr.Event{{ID: "1"}, {ID: "2"}}, nil
+		},
+		"wss://b.test": func() ([]nostr.Event, error) {
+			return []nostr.Event{{ID: "2"}, {ID: "3"}}, nil
+		},
+	})
+
+	fetched, err := FetchEvents(context.Background(), nostr.Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fetched.Events) != 3 {
+		t.Errorf("got %d events, want 3 after de-duplication", len(fetched.Events))
+	}
+	if strings.Join(fetched.Relays.Answered, ",") != "wss://a.test,wss://b.test" || fetched.Relays.Failed != nil {
+		t.Errorf("relays = %+v", fetched.Relays)
+	}
+}
+
+func TestFetchEventsPartialFailure(t *testing.T) {
+	stubRelays(t, map[string]func() ([]nostr.Event, error){
+		"wss://a.test": func() ([]nostr.Event, error) {
+			return []nostr.Event{{ID: "1"}}, nil
+		},
+		// Events received before a timeout are kept
+		"wss://b.test": func() ([]nostr.Event, error) {
+			return []nostr.Event{{ID: "2"}}, errors.New("timed out waiting for end of stored events")
+		},
+	})
+
+	fetched, err := FetchEvents(context.Background(), nostr.Filter{})
+	if err != nil {
+		t.Fatalf("FetchEvents with one relay answering = %v", err)
+	}
+	if len(fetched.Events) != 2 {
+		t.Errorf("got %d events, want 2", len(fetched.Events))
+	}
+	if len(fetched.Relays.Answered) != 1 || fetched.Relays.Failed["wss://b.test"] == "" {
+		t.Errorf("relays = %+v, want a answered and b failed", fetched.Relays)
+	}
+}
+
+func TestFetchEventsFailsWhenNoRelayAnswers(t *testing.T) {
+	stubRelays(t, map[string]func() ([]nostr.Event, error){
+		"wss://a.test": func() ([]nostr.Event, error) { return nil, errors.New("refused") },
+		"wss://b.test": func() ([]nostr.Event, error) { return nil, errors.New("refused") },
+	})
+
+	fetched, err := FetchEvents(context.Background(), nostr.Filter{})
+	if err == nil {
+		t.Fatal("FetchEvents succeeded with every relay failing")
+	}
+	if len(fetched.Relays.Failed) != 2 {
+		t.Errorf("failed = %v, want both relays", fetched.Relays.Failed)
+	}
+}
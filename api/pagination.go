@@ -0,0 +1,197 @@
+package api
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const (
+	defaultPageLimit = 64
+	maxPageLimit     = 500
+)
+
+// ChannelEventsResponse is the body returned by /channel/{id}. Pages are cut
+// over channel messages, so a page may hold fewer videos than its limit.
+type ChannelEventsResponse struct {
+	Videos []Video      `json:"videos"`
+	Next   string       `json:"next,omitempty"`
+	Relays *RelayReport `json:"relays,omitempty"`
+}
+
+// eventCursor marks the last event of a page. Pages are ordered by created_at
+// descending, then ID descending, so the cursor is unambiguous even when
+// several events share a timestamp.
+type eventCursor struct {
+	CreatedAt nostr.Timestamp
+	ID        string
+}
+
+// String encodes the cursor as an opaque token.
+func (c eventCursor) String() string {
+	raw := fmt.Sprintf("%d:%s", c.CreatedAt, c.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// parseEventCursor decodes a token produced by eventCursor.String.
+func parseEventCursor(token string) (*eventCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+
+	createdAt, id, ok := strings.Cut(string(raw), ":")
+	if !ok || !isValidChannelID(id) {
+		return nil, errors.New("invalid cursor")
+	}
+	ts, err := strconv.ParseInt(createdAt, 10, 64)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	return &eventCursor{CreatedAt: nostr.Timestamp(ts), ID: id}, nil
+}
+
+// lastEventID sorts after every other event ID, so a cursor holding it
+// starts at the newest event of its second.
+var lastEventID = strings.Repeat("f", 64)
+
+// before reports whether ev sorts strictly after the cursor, i.e. is older.
+func (c eventCursor) before(ev nostr.Event) bool {
+	if ev.CreatedAt != c.CreatedAt {
+		return ev.CreatedAt < c.CreatedAt
+	}
+	return ev.ID < c.ID
+}
+
+// channelPage is a page request for /channel/{id}.
+type channelPage struct {
+	since  *nostr.Timestamp
+	until  *nostr.Timestamp
+	limit  int
+	cursor *eventCursor
+}
+
+// parseChannelPage reads since, until, limit and cursor query parameters.
+func parseChannelPage(values url.Values) (channelPage, error) {
+	page := channelPage{limit: defaultPageLimit}
+
+	for _, param := range []struct {
+		name string
+		dst  **nostr.Timestamp
+	}{{"since", &page.since}, {"until", &page.until}} {
+		v := values.Get(param.name)
+		if v == "" {
+			continue
+		}
+		ts, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || ts < 0 {
+			return channelPage{}, fmt.Errorf("invalid %s %q: expected a unix timestamp", param.name, v)
+		}
+		t := nostr.Timestamp(ts)
+		*param.dst = &t
+	}
+
+	if v := values.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			return channelPage{}, fmt.Errorf("invalid limit %q: must be between 1 and %d", v, maxPageLimit)
+		}
+		page.limit = limit
+	}
+
+	if v := values.Get("cursor"); v != "" {
+		cursor, err := parseEventCursor(v)
+		if err != nil {
+			return channelPage{}, err
+		}
+		page.cursor = cursor
+	}
+	return page, nil
+}
+
+// filter builds the relay filter for the page. It asks for one extra event so
+// apply can tell whether another page exists.
+func (p channelPage) filter(channelID string) nostr.Filter {
+	filter := nostr.Filter{
+		Kinds: []int{nostr.KindChannelMessage},
+		Tags:  nostr.TagMap{"e": []string{channelID}},
+		Since: p.since,
+		Until: p.until,
+		Limit: p.limit + 1,
+	}
+
+	// Relays treat until as inclusive, so events sharing the cursor's
+	// timestamp come back and are skipped in apply.
+	if p.cursor != nil && (filter.Until == nil || p.cursor.CreatedAt < *filter.Until) {
+		until := p.cursor.CreatedAt
+		filter.Until = &until
+	}
+	return filter
+}
+
+// apply orders events newest first, drops duplicates and anything at or before
+// the cursor, and trims the result to the page size. It returns the cursor for
+// the next page, or "" when the history is exhausted.
+//
+// A page can come back empty while the relay still had more, when more than
+// limit events share the cursor's timestamp. The cursor then moves past that
+// second, since relays cannot page within it; the rest of it is skipped.
+func (p channelPage) apply(events []nostr.Event) ([]nostr.Event, string) {
+	fetched := len(events)
+
+	seen := make(map[string]bool, len(events))
+	page := make([]nostr.Event, 0, len(events))
+	for _, ev := range events {
+		if seen[ev.ID] {
+			continue
+		}
+		seen[ev.ID] = true
+
+		if p.cursor != nil && !p.cursor.before(ev) {
+			continue
+		}
+		if p.since != nil && ev.CreatedAt < *p.since {
+			continue
+		}
+		if p.until != nil && ev.CreatedAt > *p.until {
+			continue
+		}
+		page = append(page, ev)
+	}
+
+	sort.Slice(page, func(i, j int) bool {
+		if page[i].CreatedAt != page[j].CreatedAt {
+			return page[i].CreatedAt > page[j].CreatedAt
+		}
+		return page[i].ID > page[j].ID
+	})
+
+	hasMore := len(page) > p.limit || fetched > p.limit
+	if len(page) > p.limit {
+		page = page[:p.limit]
+	}
+	if !hasMore {
+		return page, ""
+	}
+	if len(page) == 0 {
+		oldest := events[0].CreatedAt
+		for _, ev := range events {
+			if ev.CreatedAt < oldest {
+				oldest = ev.CreatedAt
+			}
+		}
+		if oldest == 0 {
+			return page, ""
+		}
+		return page, eventCursor{CreatedAt: oldest - 1, ID: lastEventID}.String()
+	}
+
+	last := page[len(page)-1]
+	return page, eventCursor{CreatedAt: last.CreatedAt, ID: last.ID}.String()
+}
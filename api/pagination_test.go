@@ -0,0 +1,121 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func testEvent(createdAt nostr.Timestamp, n int) nostr.Event {
+	return nostr.Event{ID: fmt.Sprintf("%064x", n), CreatedAt: createdAt}
+}
+
+func TestEventCursorRoundTrip(t *testing.T) {
+	cursor := eventCursor{CreatedAt: 1700000000, ID: fmt.Sprintf("%064x", 42)}
+	parsed, err := parseEventCursor(cursor.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *parsed != cursor {
+		t.Errorf("parsed = %+v, want %+v", *parsed, cursor)
+	}
+
+	for _, token := range []string{"!", "MTIz", cursor.String() + "x"} {
+		if _, err := parseEventCursor(token); err == nil {
+			t.Errorf("parseEventCursor(%q) succeeded, want error", token)
+		}
+	}
+}
+
+func TestChannelPageWalksHistory(t *testing.T) {
+	// Five events, two sharing a timestamp
+	all := []nostr.Event{
+		testEvent(100, 1), testEvent(90, 2), testEvent(90, 3), testEvent(80, 4), testEvent(70, 5),
+	}
+	relay := func(filter nostr.Filter) []nostr.Event {
+		var out []nostr.Event
+		for _, ev := range all {
+			if filter.Until != nil && ev.CreatedAt > *filter.Until {
+				continue
+			}
+			if len(out) < filter.Limit {
+				out = append(out, ev)
+			}
+		}
+		return out
+	}
+
+	var got []string
+	page := channelPage{limit: 2}
+	for i := 0; i < 10; i++ {
+		events, next := page.apply(relay(page.filter("")))
+		for _, ev := range events {
+			got = append(got, ev.ID[62:])
+		}
+		if next == "" {
+			break
+		}
+		cursor, err := parseEventCursor(next)
+		if err != nil {
+			t.Fatal(err)
+		}
+		page.cursor = cursor
+	}
+
+	want := "[01 03 02 04 05]"
+	if fmt.Sprint(got) != want {
+		t.Errorf("pages = %v, want %s", got, want)
+	}
+}
+
+func TestChannelPageEmptyPageKeepsCursor(t *testing.T) {
+	cursor := &eventCursor{CreatedAt: 100, ID: fmt.Sprintf("%064x", 1)}
+	page := channelPage{limit: 2, cursor: cursor}
+
+	// The relay returns only events at the cursor's timestamp, all at or
+	// before the cursor itself, and has more.
+	events, next := page.apply([]nostr.Event{testEvent(100, 1), testEvent(100, 5), testEvent(100, 7)})
+	if len(events) != 0 {
+		t.Fatalf("page has %d events, want 0", len(events))
+	}
+	if next == "" {
+		t.Fatal("empty page ended the history")
+	}
+
+	page.cursor, _ = parseEventCursor(next)
+	if until := page.filter("").Until; until == nil || *until != 99 {
+		t.Errorf("next filter until = %v, want 99", until)
+	}
+	events, _ = page.apply([]nostr.Event{testEvent(99, 2)})
+	if len(events) != 1 {
+		t.Errorf("next page has %d events, want 1", len(events))
+	}
+}
+
+func TestChannelPageLastPage(t *testing.T) {
+	page := channelPage{limit: 3}
+	events, next := page.apply([]nostr.Event{testEvent(10, 1), testEvent(20, 2), testEvent(20, 2)})
+	if len(events) != 2 || next != "" {
+		t.Errorf("got %d events and next %q, want 2 and none", len(events), next)
+	}
+}
+
+func TestParseChannelPage(t *testing.T) {
+	for _, query := range []string{"limit=0", "limit=501", "since=-1", "until=x", "cursor=!"} {
+		values, _ := url.ParseQuery(query)
+		if _, err := parseChannelPage(values); err == nil {
+			t.Errorf("parseChannelPage(%q) succeeded, want error", query)
+		}
+	}
+
+	values, _ := url.ParseQuery("limit=10&since=5")
+	page, err := parseChannelPage(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.limit != 10 || page.since == nil || *page.since != 5 {
+		t.Errorf("page = %+v, want limit 10 since 5", page)
+	}
+}
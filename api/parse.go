@@ -0,0 +1,20 @@
+package api
+
+import (
+	"errors"
+	"math"
+	"strconv"
+)
+
+// parseFiniteFloat parses a float like strconv.ParseFloat but rejects NaN and
+// infinities, which JSON cannot encode.
+func parseFiniteFloat(s string) (float64, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, errors.New("not a finite number")
+	}
+	return f, nil
+}
@@ -0,0 +1,14 @@
+package api
+
+import "testing"
+
+func TestParseFiniteFloat(t *testing.T) {
+	if f, err := parseFiniteFloat("1.5"); err != nil || f != 1.5 {
+		t.Errorf("parseFiniteFloat(1.5) = %v, %v", f, err)
+	}
+	for _, s := range []string{"", "x", "NaN", "+Inf", "-inf", "infinity"} {
+		if _, err := parseFiniteFloat(s); err == nil {
+			t.Errorf("parseFiniteFloat(%q) succeeded, want error", s)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const (
+	relayDialTimeout      = 10 * time.Second
+	relayKeepAlivePeriod  = 15 * time.Second
+	relayMaxReconnectWait = 5 * time.Minute
+)
+
+// ErrPoolClosed is returned by a RelayPool after Close.
+var ErrPoolClosed = errors.New("relay pool closed")
+
+// errRelayBackoff is returned for a relay that failed to connect recently,
+// until its next attempt is due.
+var errRelayBackoff = errors.New("relay unavailable after failed connection attempts")
+
+// poolEntry is a single relay connection. Only one dial runs at a time:
+// dialing is set while it does, and concurrent callers wait for it to be
+// closed instead of dialing themselves. mu is never held across a dial.
+type poolEntry struct {
+	mu          sync.Mutex
+	url         string
+	relay       *nostr.Relay
+	dialing     chan struct{}
+	failures    int
+	nextAttempt time.Time
+}
+
+// RelayPool keeps long-lived relay connections that are shared across
+// requests and re-established in the background when they drop.
+type RelayPool struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	mu      sync.Mutex
+	entries map[string]*poolEntry
+	done    chan struct{}
+}
+
+// NewRelayPool creates a pool and starts its background reconnect loop.
+func NewRelayPool() *RelayPool {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &RelayPool{
+		ctx:     ctx,
+		cancel:  cancel,
+		entries: make(map[string]*poolEntry),
+		done:    make(chan struct{}),
+	}
+	go p.keepAlive()
+	return p
+}
+
+// Relay returns a connected relay for url, dialing it if necessary. While
+// the relay is backing off after failed attempts it fails at once rather
+// than dialing again.
+func (p *RelayPool) Relay(ctx context.Context, url string) (*nostr.Relay, error) {
+	if p.ctx.Err() != nil {
+		return nil, ErrPoolClosed
+	}
+
+	p.mu.Lock()
+	entry, ok := p.entries[url]
+	if !ok {
+		entry = &poolEntry{url: url}
+		p.entries[url] = entry
+	}
+	p.mu.Unlock()
+
+	for {
+		entry.mu.Lock()
+		if entry.relay != nil && entry.relay.IsConnected() {
+			relay := entry.relay
+			entry.mu.Unlock()
+			return relay, nil
+		}
+		if dialing := entry.dialing; dialing != nil {
+			entry.mu.Unlock()
+			select {
+			case <-dialing:
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		if wait := time.Until(entry.nextAttempt); wait > 0 {
+			entry.mu.Unlock()
+			return nil, fmt.Errorf("%w: %s, retrying in %v", errRelayBackoff, url, wait.Round(time.Second))
+		}
+		entry.dialing = make(chan struct{})
+		entry.mu.Unlock()
+
+		return p.dial(ctx, entry)
+	}
+}
+
+// Subscribe opens a subscription on url using a pooled connection.
+func (p *RelayPool) Subscribe(ctx context.Context, url string, filters nostr.Filters) (*nostr.Subscription, error) {
+	relay, err := p.Relay(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+
+	sub, err := relay.Subscribe(ctx, filters)
+	if err != nil {
+		return nil, fmt.Errorf("failed to subscribe to %s: %v", url, err)
+	}
+	return sub, nil
+}
+
+// Follow keeps a subscription open on url until ctx is cancelled, passing
+// every event to handle. filters is called before each subscription, so it
+// can move since forward or change what is followed; while it returns none,
+// Follow waits. The subscription is reopened at once when a value arrives on
+// refresh, which may be nil, and after relayKeepAlivePeriod when it drops.
+// filters and handle are called on Follow's goroutine.
+func (p *RelayPool) Follow(ctx context.Context, url string, filters func() nostr.Filters, refresh <-chan struct{}, handle func(*nostr.Event)) {
+	for ctx.Err() == nil {
+		if f := filters(); len(f) > 0 && p.follow(ctx, url, f, refresh, handle) {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-refresh:
+		case <-time.After(relayKeepAlivePeriod):
+		}
+	}
+}
+
+// follow runs one subscription for Follow, reporting whether it ended
+// because of a refresh rather than failing or dropping.
+func (p *RelayPool) follow(ctx context.Context, url string, filters nostr.Filters, refresh <-chan struct{}, handle func(*nostr.Event)) bool {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	sub, err := p.Subscribe(ctx, url, filters)
+	if err != nil {
+		log.Printf("Relay pool failed to follow %s: %v", url, err)
+		return false
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-refresh:
+			return true
+		case ev, ok := <-sub.Events:
+			if !ok {
+				log.Printf("Relay pool subscription to %s ended, resubscribing...", url)
+				return false
+			}
+			handle(ev)
+		}
+	}
+}
+
+// Close disconnects every relay and stops the reconnect loop.
+func (p *RelayPool) Close() {
+	p.cancel()
+	<-p.done
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for url, entry := range p.entries {
+		entry.mu.Lock()
+		if entry.relay != nil {
+			entry.relay.Close()
+		}
+		entry.mu.Unlock()
+		delete(p.entries, url)
+	}
+}
+
+// dial connects entry's relay. The relay lives as long as the pool; ctx only
+// bounds the connection attempt. Callers must have set entry.dialing, which
+// dial closes once the attempt is recorded. A failure backs the relay off
+// unless it came from ctx ending.
+func (p *RelayPool) dial(ctx context.Context, entry *poolEntry) (*nostr.Relay, error) {
+	dialCtx, cancel := context.WithTimeout(ctx, relayDialTimeout)
+	defer cancel()
+
+	relay := nostr.NewRelay(p.ctx, entry.url)
+	err := relay.Connect(dialCtx)
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	close(entry.dialing)
+	entry.dialing = nil
+
+	if err != nil {
+		if ctx.Err() == nil {
+			entry.failures++
+			entry.nextAttempt = time.Now().Add(reconnectBackoff(entry.failures))
+		}
+		return nil, fmt.Errorf("failed to connect to relay %s: %v", entry.url, err)
+	}
+	if p.ctx.Err() != nil {
+		relay.Close()
+		return nil, ErrPoolClosed
+	}
+
+	if entry.relay != nil {
+		entry.relay.Close()
+	}
+	entry.relay = relay
+	entry.failures = 0
+	return relay, nil
+}
+
+// keepAlive periodically redials relays that have dropped, backing off
+// exponentially on repeated failures.
+func (p *RelayPool) keepAlive() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(relayKeepAlivePeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		p.mu.Lock()
+		entries := make([]*poolEntry, 0, len(p.entries))
+		for _, entry := range p.entries {
+			entries = append(entries, entry)
+		}
+		p.mu.Unlock()
+
+		for _, entry := range entries {
+			entry.mu.Lock()
+			due := (entry.relay == nil || !entry.relay.IsConnected()) &&
+				entry.dialing == nil && time.Now().After(entry.nextAttempt)
+			if due {
+				entry.dialing = make(chan struct{})
+			}
+			entry.mu.Unlock()
+			if !due {
+				continue
+			}
+
+			if _, err := p.dial(p.ctx, entry); err != nil {
+				log.Printf("Relay pool reconnect failed: %v", err)
+			} else {
+				log.Printf("Relay pool reconnected to %s", entry.url)
+			}
+		}
+	}
+}
+
+// reconnectBackoff returns how long to wait after the given number of consecutive failures.
+func reconnectBackoff(failures int) time.Duration {
+	if failures > 10 {
+		failures = 10
+	}
+	wait := time.Second << failures
+	if wait > relayMaxReconnectWait {
+		return relayMaxReconnectWait
+	}
+	return wait
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestFollowWaitsForFilters(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	refresh := make(chan struct{})
+	calls := make(chan struct{}, 10)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		filters := func() nostr.Filters {
+			calls <- struct{}{}
+			return nil
+		}
+		(&RelayPool{}).Follow(ctx, "wss://relay.test", filters, refresh, func(*nostr.Event) {
+			t.Error("handle called without a subscription")
+		})
+	}()
+
+	<-calls
+	refresh <- struct{}{}
+	select {
+	case <-calls:
+	case <-time.After(time.Second):
+		t.Fatal("a refresh did not ask for the filters again")
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Follow did not return after ctx was cancelled")
+	}
+}
+
+func TestRelayFailsFastWhileBackingOff(t *testing.T) {
+	p := NewRelayPool()
+	defer p.Close()
+	url := "wss://relay.test"
+	entry := &poolEntry{url: url, failures: 3, nextAttempt: time.Now().Add(time.Hour)}
+	p.entries[url] = entry
+
+	start := time.Now()
+	if _, err := p.Relay(context.Background(), url); !errors.Is(err, errRelayBackoff) {
+		t.Fatalf("Relay while backing off = %v, want errRelayBackoff", err)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("Relay took %v while backing off", elapsed)
+	}
+}
+
+func TestRelayWaitsForDialInFlight(t *testing.T) {
+	p := NewRelayPool()
+	defer p.Close()
+	url := "wss://relay.test"
+	dialing := make(chan struct{})
+	entry := &poolEntry{url: url, dialing: dialing}
+	p.entries[url] = entry
+
+	// A caller gives up waiting for someone else's dial without dialing
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := p.Relay(ctx, url); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Relay during a dial = %v, want the caller's deadline", err)
+	}
+
+	// When that dial fails, waiters get its backoff rather than redialing
+	result := make(chan error, 1)
+	go func() {
+		_, err := p.Relay(context.Background(), url)
+		result <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	entry.mu.Lock()
+	entry.failures = 1
+	entry.nextAttempt = time.Now().Add(time.Hour)
+	close(dialing)
+	entry.dialing = nil
+	entry.mu.Unlock()
+
+	select {
+	case err := <-result:
+		if !errors.Is(err, errRelayBackoff) {
+			t.Errorf("Relay after the dial failed = %v, want errRelayBackoff", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Relay did not return after the dial finished")
+	}
+}
@@ -0,0 +1,325 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// presenceFlushPeriod is how often presence diffs are broadcast and silent
+// clients are timed out.
+const presenceFlushPeriod = 2 * time.Second
+
+// presence tracks who is in each /ws room.
+var presence *Presence
+
+func init() {
+	HandleWS(msgHeartbeat, handleHeartbeat)
+	HandleWS(msgPresence, handlePresenceFrame)
+}
+
+// PresenceDiff is the payload of the "presence" frames pushed to a room: the
+// pubkeys that arrived and left since the last diff, and how many clients
+// are present now, anonymous ones included. Each instance sends its own
+// diffs to its own clients.
+type PresenceDiff struct {
+	Joined []string `json:"joined,omitempty"`
+	Left   []string `json:"left,omitempty"`
+	Count  int      `json:"count"`
+}
+
+// PresenceSnapshot is who is present in a room on this instance.
+type PresenceSnapshot struct {
+	Room    string   `json:"room"`
+	Pubkeys []string `json:"pubkeys"`
+	Count   int      `json:"count"`
+}
+
+// roomPresence is the presence state of one room. A member is present
+// until it leaves or sends no heartbeat for the presence timeout.
+type roomPresence struct {
+	members map[*Client]time.Time // last heartbeat
+	present map[*Client]bool
+	pubkeys map[string]int // present clients per authenticated pubkey
+
+	// Changes not yet broadcast
+	changed bool
+	joined  map[string]bool
+	left    map[string]bool
+
+	announced   int
+	announcedAt time.Time
+}
+
+// Presence tracks the members of each room from the hub's join and leave
+// hooks and their heartbeats, and broadcasts batched diffs to the room. It
+// only sees the clients connected to this instance: with a shared broadcast
+// backend, snapshots and diffs cover this instance's share of the room.
+//
+// With PRESENCE_ANNOUNCE set, channel rooms also get an anonymous "N skaters
+// here" message posted to their NIP-28 channel by the hub, at most every
+// PRESENCE_ANNOUNCE_INTERVAL and only when the count changed. Announcements
+// are disabled when rooms are shared, since every instance would post its
+// own count.
+type Presence struct {
+	hub           *Hub
+	timeout       time.Duration
+	announceEvery time.Duration
+
+	mu    sync.Mutex
+	rooms map[string]*roomPresence
+}
+
+// NewPresence creates a tracker for hub's rooms and hooks it into the hub;
+// call it before the hub runs.
+func NewPresence(hub *Hub) *Presence {
+	p := &Presence{
+		hub:     hub,
+		timeout: envDuration("PRESENCE_TIMEOUT", 90*time.Second),
+		rooms:   make(map[string]*roomPresence),
+	}
+	if os.Getenv("PRESENCE_ANNOUNCE") != "" {
+		if hub.Shared() {
+			log.Println("⚠️ PRESENCE_ANNOUNCE ignored: presence is per instance with a shared broadcast backend")
+		} else {
+			p.announceEvery = envDuration("PRESENCE_ANNOUNCE_INTERVAL", 15*time.Minute)
+		}
+	}
+
+	hub.MemberJoined = p.memberJoined
+	hub.MemberLeft = p.memberLeft
+	return p
+}
+
+func (p *Presence) memberJoined(client *Client, room string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	state, ok := p.rooms[room]
+	if !ok {
+		state = &roomPresence{
+			members: make(map[*Client]time.Time),
+			present: make(map[*Client]bool),
+			pubkeys: make(map[string]int),
+			joined:  make(map[string]bool),
+			left:    make(map[string]bool),
+		}
+		p.rooms[room] = state
+	}
+	state.members[client] = time.Now()
+	state.markPresent(client)
+}
+
+func (p *Presence) memberLeft(client *Client, room string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if state, ok := p.rooms[room]; ok {
+		delete(state.members, client)
+		state.markAbsent(client)
+	}
+}
+
+// Heartbeat keeps client present in every room it has joined.
+func (p *Presence) Heartbeat(client *Client) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	for _, state := range p.rooms {
+		if _, ok := state.members[client]; ok {
+			state.members[client] = now
+			state.markPresent(client)
+		}
+	}
+}
+
+// Snapshot returns who is present in room.
+func (p *Presence) Snapshot(room string) PresenceSnapshot {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	snapshot := PresenceSnapshot{Room: room, Pubkeys: []string{}}
+	if state, ok := p.rooms[room]; ok {
+		for pubkey := range state.pubkeys {
+			snapshot.Pubkeys = append(snapshot.Pubkeys, pubkey)
+		}
+		sort.Strings(snapshot.Pubkeys)
+		snapshot.Count = len(state.present)
+	}
+	return snapshot
+}
+
+func (s *roomPresence) markPresent(client *Client) {
+	if s.present[client] {
+		return
+	}
+	s.present[client] = true
+	s.changed = true
+	if client.pubkey == "" {
+		return
+	}
+	s.pubkeys[client.pubkey]++
+	if s.pubkeys[client.pubkey] == 1 {
+		if s.left[client.pubkey] {
+			delete(s.left, client.pubkey)
+		} else {
+			s.joined[client.pubkey] = true
+		}
+	}
+}
+
+func (s *roomPresence) markAbsent(client *Client) {
+	if !s.present[client] {
+		return
+	}
+	delete(s.present, client)
+	s.changed = true
+	if client.pubkey == "" {
+		return
+	}
+	s.pubkeys[client.pubkey]--
+	if s.pubkeys[client.pubkey] == 0 {
+		delete(s.pubkeys, client.pubkey)
+		if s.joined[client.pubkey] {
+			delete(s.joined, client.pubkey)
+		} else {
+			s.left[client.pubkey] = true
+		}
+	}
+}
+
+// Run times out silent clients and broadcasts presence diffs until ctx is
+// cancelled.
+func (p *Presence) Run(ctx context.Context) {
+	ticker := time.NewTicker(presenceFlushPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			p.flush(now)
+		}
+	}
+}
+
+// flush broadcasts the pending diff of every changed room and posts due
+// announcements. The hub is only called after the lock is released, since
+// its hooks take the lock too.
+func (p *Presence) flush(now time.Time) {
+	type roomDiff struct {
+		room string
+		diff PresenceDiff
+	}
+	var diffs []roomDiff
+	announce := make(map[string]int)
+
+	p.mu.Lock()
+	for room, state := range p.rooms {
+		for client, seen := range state.members {
+			if now.Sub(seen) > p.timeout {
+				state.markAbsent(client)
+			}
+		}
+
+		if state.changed && len(state.members) > 0 {
+			diff := PresenceDiff{Count: len(state.present)}
+			for pubkey := range state.joined {
+				diff.Joined = append(diff.Joined, pubkey)
+			}
+			for pubkey := range state.left {
+				diff.Left = append(diff.Left, pubkey)
+			}
+			sort.Strings(diff.Joined)
+			sort.Strings(diff.Left)
+			diffs = append(diffs, roomDiff{room, diff})
+		}
+		state.changed = false
+		state.joined = make(map[string]bool)
+		state.left = make(map[string]bool)
+
+		count := len(state.present)
+		if p.announceEvery > 0 && isValidChannelID(room) && count > 0 &&
+			count != state.announced && now.Sub(state.announcedAt) >= p.announceEvery {
+			state.announced = count
+			state.announcedAt = now
+			announce[room] = count
+		}
+
+		if len(state.members) == 0 {
+			delete(p.rooms, room)
+		}
+	}
+	p.mu.Unlock()
+
+	for _, d := range diffs {
+		data, err := newFrame(msgPresence, "", d.room, d.diff)
+		if err != nil {
+			log.Println("Error marshalling JSON:", err)
+			continue
+		}
+		p.hub.BroadcastLocal(d.room, data)
+	}
+	for room, count := range announce {
+		if err := bridge.Announce(room, skatersHere(count)); err != nil {
+			log.Printf("Failed to announce presence in channelId=%s: %v", room, err)
+		}
+	}
+}
+
+// skatersHere is the text of a presence announcement.
+func skatersHere(count int) string {
+	if count == 1 {
+		return "1 skater here right now"
+	}
+	return fmt.Sprintf("%d skaters here right now", count)
+}
+
+// handleHeartbeat keeps the client present in its rooms. Clients should send
+// one well within PRESENCE_TIMEOUT.
+func handleHeartbeat(c *Client, env Envelope) (interface{}, error) {
+	presence.Heartbeat(c)
+	return nil, nil
+}
+
+// handlePresenceFrame answers with who is present in a room.
+func handlePresenceFrame(c *Client, env Envelope) (interface{}, error) {
+	room, err := frameRoom(env)
+	if err != nil {
+		return nil, err
+	}
+	return presence.Snapshot(room), nil
+}
+
+// handlePresence serves who is present in a room at /presence/{room}, where
+// room is a channel ID or lead ID. Like the snapshot frame, it only reports
+// the clients connected to the instance that answers.
+func handlePresence(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if presence == nil {
+		http.Error(w, "Presence not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	room, err := resolveRoom(strings.TrimPrefix(r.URL.Path, "/presence/"))
+	if errors.Is(err, ErrLeadNotFound) {
+		http.Error(w, "Lead not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sendJSONResponse(w, presence.Snapshot(room))
+}
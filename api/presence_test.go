@@ -0,0 +1,27 @@
+package api
+
+import (
+	"testing"
+
+	"hub/broadcast"
+)
+
+func TestPresenceAnnounceOnlyOnSingleInstance(t *testing.T) {
+	t.Setenv("PRESENCE_ANNOUNCE", "1")
+
+	if p := NewPresence(NewHub(nil)); p.announceEvery == 0 {
+		t.Error("PRESENCE_ANNOUNCE ignored on a single instance")
+	}
+
+	backend, err := broadcast.NewRedis("redis://localhost", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hub := NewHub(backend)
+	if !hub.Shared() {
+		t.Fatal("a hub on Redis is not shared")
+	}
+	if p := NewPresence(hub); p.announceEvery != 0 {
+		t.Error("shared hub announces its per-instance count")
+	}
+}
@@ -0,0 +1,264 @@
+package api
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultRateLimits apply when RATE_LIMITS is unset. "*" covers every route
+// without a more specific entry.
+const defaultRateLimits = "*=300/m,/channel/=60/m,/token=10/m,/uploads=60/m,/upload=30/m"
+
+var (
+	// rateLimited counts rejected requests by route and client kind.
+	rateLimited = expvar.NewMap("rate_limited")
+	// limiter enforces the budgets in RATE_LIMITS.
+	limiter *RateLimiter
+)
+
+// rateBudget is a token bucket refill rate and capacity.
+type rateBudget struct {
+	perSecond float64
+	burst     float64
+}
+
+// tokenBucket is the state of one client's budget on one route.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiter keeps a token bucket per route and client, where a client is an
+// IP address or an authenticated pubkey.
+type RateLimiter struct {
+	budgets map[string]rateBudget
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+// NewRateLimiter creates a limiter with the given per-route budgets.
+func NewRateLimiter(budgets map[string]rateBudget) *RateLimiter {
+	return &RateLimiter{
+		budgets: budgets,
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+func init() {
+	expvar.Publish("rate_limit_buckets", expvar.Func(func() interface{} {
+		if limiter == nil {
+			return 0
+		}
+		limiter.mu.Lock()
+		defer limiter.mu.Unlock()
+		return len(limiter.buckets)
+	}))
+}
+
+// LoadRateLimiter builds a limiter from RATE_LIMITS, a comma-separated list
+// of route=count/unit[:burst] entries such as "/token=10/m:3". Routes match
+// by longest path prefix; unit is s, m or h; burst defaults to count.
+func LoadRateLimiter() (*RateLimiter, error) {
+	spec := os.Getenv("RATE_LIMITS")
+	if spec == "" {
+		spec = defaultRateLimits
+	}
+	budgets, err := parseRateLimits(spec)
+	if err != nil {
+		return nil, err
+	}
+	return NewRateLimiter(budgets), nil
+}
+
+func parseRateLimits(spec string) (map[string]rateBudget, error) {
+	budgets := make(map[string]rateBudget)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		route, limit, ok := strings.Cut(entry, "=")
+		if !ok || route == "" {
+			return nil, fmt.Errorf("RATE_LIMITS: entries must be route=count/unit, got %q", entry)
+		}
+		limit, burstText, hasBurst := strings.Cut(limit, ":")
+		countText, unit, ok := strings.Cut(limit, "/")
+		if !ok {
+			return nil, fmt.Errorf("RATE_LIMITS: %q has no unit", entry)
+		}
+		count, err := parseFiniteFloat(countText)
+		if err != nil || count <= 0 {
+			return nil, fmt.Errorf("RATE_LIMITS: invalid count in %q", entry)
+		}
+
+		var period time.Duration
+		switch unit {
+		case "s":
+			period = time.Second
+		case "m":
+			period = time.Minute
+		case "h":
+			period = time.Hour
+		default:
+			return nil, fmt.Errorf("RATE_LIMITS: unknown unit %q in %q", unit, entry)
+		}
+
+		budget := rateBudget{perSecond: count / period.Seconds(), burst: count}
+		if hasBurst {
+			burst, err := parseFiniteFloat(burstText)
+			if err != nil || burst < 1 {
+				return nil, fmt.Errorf("RATE_LIMITS: invalid burst in %q", entry)
+			}
+			budget.burst = burst
+		}
+		budgets[route] = budget
+	}
+	return budgets, nil
+}
+
+// budgetFor returns the route entry matching path and its budget, reporting
+// false if the path is unlimited.
+func (l *RateLimiter) budgetFor(path string) (string, rateBudget, bool) {
+	best := ""
+	for route := range l.budgets {
+		if route != "*" && strings.HasPrefix(path, route) && len(route) > len(best) {
+			best = route
+		}
+	}
+	if best == "" {
+		best = "*"
+	}
+	budget, ok := l.budgets[best]
+	return best, budget, ok
+}
+
+// Allow takes a token from client's bucket for path. When the bucket is
+// empty it reports false and how long until a token is available.
+func (l *RateLimiter) Allow(path, client string) (bool, time.Duration) {
+	route, budget, ok := l.budgetFor(path)
+	if !ok {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := route + " " + client
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{}
+		l.buckets[key] = bucket
+	}
+	return bucket.take(budget, time.Now())
+}
+
+// take refills the bucket for the time since it was last used, starting
+// full, and takes a token. When none is left it reports false and how long
+// until one is available.
+func (b *tokenBucket) take(budget rateBudget, now time.Time) (bool, time.Duration) {
+	if b.last.IsZero() {
+		b.tokens = budget.burst
+	} else {
+		b.tokens = math.Min(budget.burst, b.tokens+now.Sub(b.last).Seconds()*budget.perSecond)
+	}
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / budget.perSecond * float64(time.Second))
+	return false, wait
+}
+
+// Run drops idle buckets that have refilled, until ctx is cancelled.
+func (l *RateLimiter) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			l.mu.Lock()
+			for key, bucket := range l.buckets {
+				route, _, _ := strings.Cut(key, " ")
+				budget := l.budgets[route]
+				if bucket.tokens+now.Sub(bucket.last).Seconds()*budget.perSecond >= budget.burst {
+					delete(l.buckets, key)
+				}
+			}
+			l.mu.Unlock()
+		}
+	}
+}
+
+// rateLimitByIP limits every request by client IP. CORS preflights are not
+// counted.
+func rateLimitByIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodOptions && !allowRequest(w, r, "ip", "ip:"+clientIP(r)) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimitByPubkey limits requests by the pubkey authenticated by
+// requireNostrAuth or requireToken. Unauthenticated requests pass through.
+func rateLimitByPubkey(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		pubkey, _ := PubkeyFromContext(r.Context())
+		if claims, ok := TokenClaimsFromContext(r.Context()); ok && pubkey == "" {
+			pubkey = claims.Subject
+		}
+		if pubkey != "" && !allowRequest(w, r, "pubkey", "pubkey:"+pubkey) {
+			return
+		}
+		next(w, r)
+	}
+}
+
+// allowRequest takes a token for client, writing a 429 with Retry-After when
+// none is left.
+func allowRequest(w http.ResponseWriter, r *http.Request, kind, client string) bool {
+	if limiter == nil {
+		return true
+	}
+	ok, wait := limiter.Allow(r.URL.Path, client)
+	if ok {
+		return true
+	}
+
+	route, _, _ := limiter.budgetFor(r.URL.Path)
+	rateLimited.Add(route+" "+kind, 1)
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, "Too many requests", http.StatusTooManyRequests)
+	return false
+}
+
+// clientIP returns the caller's address. With TRUST_PROXY set, the last
+// X-Forwarded-For entry (the one added by our proxy) is used instead of the
+// connection's address.
+func clientIP(r *http.Request) string {
+	if os.Getenv("TRUST_PROXY") != "" {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			hops := strings.Split(forwarded, ",")
+			return strings.TrimSpace(hops[len(hops)-1])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
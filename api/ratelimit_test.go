@@ -0,0 +1,136 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseRateLimits(t *testing.T) {
+	budgets, err := parseRateLimits("*=300/m, /token=10/m:3,/upload=2/s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := budgets["*"]; b.perSecond != 5 || b.burst != 300 {
+		t.Errorf("* = %+v, want 5/s burst 300", b)
+	}
+	if b := budgets["/token"]; b.burst != 3 {
+		t.Errorf("/token burst = %v, want 3", b.burst)
+	}
+	if b := budgets["/upload"]; b.perSecond != 2 || b.burst != 2 {
+		t.Errorf("/upload = %+v, want 2/s burst 2", b)
+	}
+
+	for _, spec := range []string{
+		"/token",
+		"/token=10",
+		"/token=10/d",
+		"/token=0/m",
+		"/token=x/m",
+		"/token=NaN/m",
+		"/token=Inf/m",
+		"/token=10/m:0",
+		"/token=10/m:Inf",
+		"=10/m",
+	} {
+		if _, err := parseRateLimits(spec); err == nil {
+			t.Errorf("parseRateLimits(%q) succeeded, want error", spec)
+		}
+	}
+}
+
+func TestBudgetForLongestPrefix(t *testing.T) {
+	l := NewRateLimiter(map[string]rateBudget{
+		"*":        {perSecond: 1, burst: 1},
+		"/upload":  {perSecond: 2, burst: 2},
+		"/uploads": {perSecond: 3, burst: 3},
+	})
+	for path, want := range map[string]string{
+		"/uploads/complete": "/uploads",
+		"/upload":           "/upload",
+		"/leads":            "*",
+	} {
+		if route, _, _ := l.budgetFor(path); route != want {
+			t.Errorf("budgetFor(%q) = %q, want %q", path, route, want)
+		}
+	}
+
+	unlimited := NewRateLimiter(map[string]rateBudget{"/token": {perSecond: 1, burst: 1}})
+	if ok, _ := unlimited.Allow("/leads", "ip:1"); !ok {
+		t.Error("a route without a budget was limited")
+	}
+}
+
+func TestAllowBurstAndRefill(t *testing.T) {
+	l := NewRateLimiter(map[string]rateBudget{"*": {perSecond: 1, burst: 2}})
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Allow("/x", "ip:1"); !ok {
+			t.Fatalf("request %d within the burst was limited", i+1)
+		}
+	}
+	ok, wait := l.Allow("/x", "ip:1")
+	if ok {
+		t.Fatal("request beyond the burst was allowed")
+	}
+	if wait <= 0 || wait > time.Second {
+		t.Errorf("wait = %v, want up to 1s", wait)
+	}
+	if ok, _ := l.Allow("/x", "ip:2"); !ok {
+		t.Error("another client shares the bucket")
+	}
+
+	// A second later one token is back
+	l.buckets["* ip:1"].last = time.Now().Add(-time.Second)
+	if ok, _ := l.Allow("/x", "ip:1"); !ok {
+		t.Error("bucket did not refill")
+	}
+	if ok, _ := l.Allow("/x", "ip:1"); ok {
+		t.Error("bucket refilled more than the elapsed time allows")
+	}
+}
+
+func TestAllowRequestWrites429(t *testing.T) {
+	previous := limiter
+	limiter = NewRateLimiter(map[string]rateBudget{"*": {perSecond: 0.1, burst: 1}})
+	defer func() { limiter = previous }()
+
+	handler := rateLimitByIP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	request := func(method string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, "/leads", nil)
+		r.RemoteAddr = "192.0.2.1:1234"
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := request(http.MethodGet); w.Code != http.StatusOK {
+		t.Fatalf("first request = %d, want 200", w.Code)
+	}
+	w := request(http.MethodGet)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request = %d, want 429", w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != "10" {
+		t.Errorf("Retry-After = %q, want 10", got)
+	}
+	if w := request(http.MethodOptions); w.Code != http.StatusOK {
+		t.Errorf("preflight = %d, want it not limited", w.Code)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.0.0.1:5555"
+	r.Header.Set("X-Forwarded-For", "203.0.113.9, 198.51.100.7")
+
+	t.Setenv("TRUST_PROXY", "")
+	if got := clientIP(r); got != "10.0.0.1" {
+		t.Errorf("clientIP without TRUST_PROXY = %q, want 10.0.0.1", got)
+	}
+	t.Setenv("TRUST_PROXY", "1")
+	if got := clientIP(r); got != "198.51.100.7" {
+		t.Errorf("clientIP with TRUST_PROXY = %q, want 198.51.100.7", got)
+	}
+}
@@ -0,0 +1,293 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInvalidRefreshToken is returned for unknown, expired or reused refresh
+// tokens.
+var ErrInvalidRefreshToken = errors.New("invalid refresh token")
+
+// ErrTokenNotOwned is returned when revoking another pubkey's token.
+var ErrTokenNotOwned = errors.New("token belongs to another pubkey")
+
+// sessions tracks refresh tokens and revoked access tokens.
+var sessions *TokenSessions
+
+// TokenPair is the response of /token and /token/refresh.
+type TokenPair struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refreshToken"`
+	ExpiresAt    int64  `json:"expiresAt"`
+}
+
+// refreshSession is the server side of a refresh token. Every refresh
+// rotates the token; the tokens descending from one /token call share a
+// family so a replayed token can revoke all of them.
+type refreshSession struct {
+	pubkey    string
+	scope     TokenScope
+	family    string
+	expiresAt time.Time
+	used      bool
+}
+
+// TokenSessions issues access/refresh token pairs and keeps the revocation
+// list checked by requireToken. State is in memory: a restart signs everyone
+// out of refresh, and revocations only need to outlive the short access
+// token TTL.
+type TokenSessions struct {
+	mu            sync.Mutex
+	refresh       map[string]*refreshSession // by SHA-256 of the token
+	revokedIDs    map[string]time.Time       // jti → token expiry
+	revokedBefore map[string]time.Time       // pubkey → tokens issued up to then are revoked
+}
+
+// NewTokenSessions creates an empty session store.
+func NewTokenSessions() *TokenSessions {
+	return &TokenSessions{
+		refresh:       make(map[string]*refreshSession),
+		revokedIDs:    make(map[string]time.Time),
+		revokedBefore: make(map[string]time.Time),
+	}
+}
+
+// Issue starts a new refresh token family for pubkey.
+func (s *TokenSessions) Issue(pubkey string, scope TokenScope) (TokenPair, error) {
+	family, err := randomToken()
+	if err != nil {
+		return TokenPair{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.issueLocked(pubkey, scope, family)
+}
+
+// issueLocked runs under s.mu, so a concurrent revocation either sees the
+// new refresh session or happens after the access token's issue time.
+func (s *TokenSessions) issueLocked(pubkey string, scope TokenScope, family string) (TokenPair, error) {
+	access, expiresAt, err := GenerateToken(pubkey, scope)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	refreshToken, err := randomToken()
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	s.refresh[hashToken(refreshToken)] = &refreshSession{
+		pubkey:    pubkey,
+		scope:     scope,
+		family:    family,
+		expiresAt: time.Now().Add(envDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour)),
+	}
+	return TokenPair{Token: access, RefreshToken: refreshToken, ExpiresAt: expiresAt.Unix()}, nil
+}
+
+// Refresh exchanges a refresh token for a new pair. Presenting a token that
+// was already exchanged revokes its whole family, since either the client or
+// an attacker holds a stolen copy.
+func (s *TokenSessions) Refresh(refreshToken string) (TokenPair, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.refresh[hashToken(refreshToken)]
+	switch {
+	case !ok || time.Now().After(session.expiresAt):
+		return TokenPair{}, ErrInvalidRefreshToken
+	case session.used:
+		s.revokeFamilyLocked(session.family)
+		log.Printf("⚠️ Refresh token reused for %s, revoked its family", session.pubkey)
+		return TokenPair{}, ErrInvalidRefreshToken
+	}
+	session.used = true
+	return s.issueLocked(session.pubkey, session.scope, session.family)
+}
+
+// RevokeRefresh revokes the family of a refresh token owned by pubkey.
+func (s *TokenSessions) RevokeRefresh(refreshToken, pubkey string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.refresh[hashToken(refreshToken)]
+	if !ok {
+		return nil
+	}
+	if session.pubkey != pubkey && !isAdmin(pubkey) {
+		return ErrTokenNotOwned
+	}
+	s.revokeFamilyLocked(session.family)
+	return nil
+}
+
+// RevokeAccess revokes one access token until it expires.
+func (s *TokenSessions) RevokeAccess(claims *TokenClaims) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.revokedIDs[claims.Id] = time.Unix(claims.ExpiresAt, 0)
+}
+
+// RevokeAll revokes every access and refresh token issued to pubkey so far.
+func (s *TokenSessions) RevokeAll(pubkey string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.revokedBefore[pubkey] = time.Now()
+	for key, session := range s.refresh {
+		if session.pubkey == pubkey {
+			delete(s.refresh, key)
+		}
+	}
+}
+
+// IsRevoked reports whether a verified access token has been revoked.
+func (s *TokenSessions) IsRevoked(claims *TokenClaims) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.revokedIDs[claims.Id]; ok {
+		return true
+	}
+	cutoff, ok := s.revokedBefore[claims.Subject]
+	return ok && !claims.issuedAt().After(cutoff)
+}
+
+func (s *TokenSessions) revokeFamilyLocked(family string) {
+	for key, session := range s.refresh {
+		if session.family == family {
+			delete(s.refresh, key)
+		}
+	}
+}
+
+// Run prunes expired sessions and revocations until ctx is cancelled.
+func (s *TokenSessions) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.mu.Lock()
+			for key, session := range s.refresh {
+				if now.After(session.expiresAt) {
+					delete(s.refresh, key)
+				}
+			}
+			for id, expiresAt := range s.revokedIDs {
+				if now.After(expiresAt) {
+					delete(s.revokedIDs, id)
+				}
+			}
+			// Access tokens issued before the cutoff have all expired
+			// once an access token TTL has passed.
+			for pubkey, cutoff := range s.revokedBefore {
+				if now.Sub(cutoff) > accessTokenTTL() {
+					delete(s.revokedBefore, pubkey)
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// handleTokenRefresh exchanges a refresh token for a new token pair.
+func handleTokenRefresh(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		RefreshToken string `json:"refreshToken"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		http.Error(w, "refreshToken is required", http.StatusBadRequest)
+		return
+	}
+
+	pair, err := sessions.Refresh(req.RefreshToken)
+	if errors.Is(err, ErrInvalidRefreshToken) {
+		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+	sendJSONResponse(w, pair)
+}
+
+// handleTokenRevoke revokes an access or refresh token belonging to the
+// NIP-98 authenticated caller, or every token issued to them when the body
+// names none.
+func handleTokenRevoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Token string `json:"token"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	pubkey, _ := PubkeyFromContext(r.Context())
+	switch {
+	case req.Token == "":
+		sessions.RevokeAll(pubkey)
+		log.Printf("Revoked all tokens for %s", pubkey)
+
+	case strings.Count(req.Token, ".") == 2:
+		claims := &TokenClaims{}
+		if err := tokenKeys.Parse(req.Token, claims); err != nil {
+			// Expired or foreign tokens need no revoking
+			break
+		}
+		if claims.Subject != pubkey && !isAdmin(pubkey) {
+			http.Error(w, "Forbidden: "+ErrTokenNotOwned.Error(), http.StatusForbidden)
+			return
+		}
+		sessions.RevokeAccess(claims)
+
+	default:
+		if err := sessions.RevokeRefresh(req.Token, pubkey); err != nil {
+			http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// randomToken returns 32 random bytes, base64url encoded.
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken is the key refresh tokens are stored under, so the session map
+// never holds usable tokens.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
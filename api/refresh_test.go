@@ -0,0 +1,156 @@
+package api
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func newTestSessions(t *testing.T) *TokenSessions {
+	t.Helper()
+	t.Setenv("JWT_ES256_KEYS", "")
+	t.Setenv("JWT_EDDSA_KEYS", "")
+	t.Setenv("JWT_HMAC_KEYS", "")
+	keys, err := LoadKeySet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	previousKeys, previousSessions := tokenKeys, sessions
+	tokenKeys = keys
+	sessions = NewTokenSessions()
+	t.Cleanup(func() { tokenKeys, sessions = previousKeys, previousSessions })
+	return sessions
+}
+
+func TestRefreshRotatesTokens(t *testing.T) {
+	s := newTestSessions(t)
+	pubkey := strings.Repeat("a", 64)
+
+	first, err := s.Issue(pubkey, TokenScope{Ops: []string{OpRead}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := s.Refresh(first.RefreshToken)
+	if err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if second.RefreshToken == first.RefreshToken || second.Token == first.Token {
+		t.Error("Refresh did not rotate the tokens")
+	}
+
+	claims, err := verifyAccessToken(second.Token)
+	if err != nil {
+		t.Fatalf("verifyAccessToken: %v", err)
+	}
+	if claims.Subject != pubkey || len(claims.Ops) != 1 || claims.Ops[0] != OpRead {
+		t.Errorf("claims = %+v, want the original scope", claims)
+	}
+
+	// Replaying the first token revokes the whole family
+	if _, err := s.Refresh(first.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("reused refresh token = %v, want ErrInvalidRefreshToken", err)
+	}
+	if _, err := s.Refresh(second.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("refresh token of a revoked family = %v, want ErrInvalidRefreshToken", err)
+	}
+}
+
+func TestRevokeRefreshChecksOwner(t *testing.T) {
+	s := newTestSessions(t)
+	owner := strings.Repeat("a", 64)
+
+	pair, err := s.Issue(owner, TokenScope{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RevokeRefresh(pair.RefreshToken, strings.Repeat("b", 64)); !errors.Is(err, ErrTokenNotOwned) {
+		t.Errorf("RevokeRefresh by another pubkey = %v, want ErrTokenNotOwned", err)
+	}
+	if err := s.RevokeRefresh(pair.RefreshToken, owner); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Refresh(pair.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("revoked refresh token = %v, want ErrInvalidRefreshToken", err)
+	}
+}
+
+func TestRevokeAccess(t *testing.T) {
+	s := newTestSessions(t)
+	pair, err := s.Issue(strings.Repeat("a", 64), TokenScope{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := verifyAccessToken(pair.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.RevokeAccess(claims)
+	if _, err := verifyAccessToken(pair.Token); err == nil {
+		t.Error("revoked access token still verifies")
+	}
+}
+
+func TestRevokeAllSpareTokensIssuedAfter(t *testing.T) {
+	s := newTestSessions(t)
+	pubkey := strings.Repeat("a", 64)
+
+	before, err := s.Issue(pubkey, TokenScope{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.RevokeAll(pubkey)
+
+	// Issued within the same second as the cutoff
+	after, err := s.Issue(pubkey, TokenScope{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := verifyAccessToken(before.Token); err == nil {
+		t.Error("token issued before RevokeAll still verifies")
+	}
+	if _, err := s.Refresh(before.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("refresh token issued before RevokeAll = %v, want ErrInvalidRefreshToken", err)
+	}
+	if _, err := verifyAccessToken(after.Token); err != nil {
+		t.Errorf("token issued after RevokeAll: %v", err)
+	}
+}
+
+func TestRefreshRacingRevokeLeavesNoLiveToken(t *testing.T) {
+	s := newTestSessions(t)
+	owner := strings.Repeat("a", 64)
+
+	for i := 0; i < 200; i++ {
+		pair, err := s.Issue(owner, TokenScope{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var wg sync.WaitGroup
+		var refreshed TokenPair
+		var refreshErr error
+		start := make(chan struct{})
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			<-start
+			refreshed, refreshErr = s.Refresh(pair.RefreshToken)
+		}()
+		go func() {
+			defer wg.Done()
+			<-start
+			s.RevokeRefresh(pair.RefreshToken, owner)
+		}()
+		close(start)
+		wg.Wait()
+
+		// Whichever ran first, the family is revoked afterwards
+		if refreshErr == nil {
+			if _, err := s.Refresh(refreshed.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+				t.Fatalf("refresh token issued while its family was revoked = %v, want ErrInvalidRefreshToken", err)
+			}
+		}
+	}
+}
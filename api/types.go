@@ -20,4 +20,12 @@ type Lead struct {
 	Icon       string     `json:"icon"`
 	Coordinate Coordinate `json:"coordinate"`
 	ChannelID  string     `json:"channelId"`
+	Pubkey     string     `json:"pubkey,omitempty"`
+}
+
+// LeadResult is a lead matched by a geospatial query, with its distance in
+// meters from the query point.
+type LeadResult struct {
+	Lead
+	Distance float64 `json:"distance"`
 }
@@ -0,0 +1,316 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"hub/storage"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// uploadPartSize is the part size used for multipart uploads.
+	uploadPartSize = 16 << 20
+	// maxUploadParts is the S3 limit on parts per multipart upload.
+	maxUploadParts = 10000
+)
+
+// blobs stores uploaded media.
+var blobs storage.BlobStore
+
+var unsafeFilenameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// UploadRequest is the body of POST /uploads.
+type UploadRequest struct {
+	ChannelID   string `json:"channelId"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"contentType"`
+	Size        int64  `json:"size"`
+}
+
+// UploadPart is a presigned URL for one part of a multipart upload.
+type UploadPart struct {
+	PartNumber int64  `json:"partNumber"`
+	URL        string `json:"url,omitempty"`
+	ETag       string `json:"etag,omitempty"`
+}
+
+// UploadResponse tells the client where to PUT the file. Small files get a
+// single URL; large ones get an upload ID and one URL per part, and must be
+// finished with POST /uploads/complete.
+type UploadResponse struct {
+	Key       string            `json:"key"`
+	Method    string            `json:"method"`
+	URL       string            `json:"url,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	UploadID  string            `json:"uploadId,omitempty"`
+	PartSize  int64             `json:"partSize,omitempty"`
+	Parts     []UploadPart      `json:"parts,omitempty"`
+	ExpiresAt int64             `json:"expiresAt"`
+}
+
+// CompleteUploadRequest is the body of POST /uploads/complete and /uploads/abort.
+type CompleteUploadRequest struct {
+	Key      string       `json:"key"`
+	UploadID string       `json:"uploadId"`
+	Parts    []UploadPart `json:"parts"`
+}
+
+// channelKeyPrefix is the storage key prefix uploads for a channel live under.
+func channelKeyPrefix(channelID string) string {
+	return "channels/" + channelID + "/"
+}
+
+// uploadKey builds a unique object key for pubkey's upload to a channel:
+// channels/<channel>/<pubkey>/<uuid>/<filename>.
+func uploadKey(channelID, pubkey, filename string) string {
+	name := strings.Trim(unsafeFilenameChars.ReplaceAllString(filename, "_"), "._")
+	if name == "" {
+		name = "upload"
+	}
+	if len(name) > 128 {
+		name = name[len(name)-128:]
+	}
+	return channelKeyPrefix(channelID) + pubkey + "/" + uuid.New().String() + "/" + name
+}
+
+// ownerFromKey returns the uploader's pubkey encoded in an upload key, or "".
+func ownerFromKey(key string) string {
+	parts := strings.SplitN(key, "/", 4)
+	if len(parts) < 4 || parts[0] != "channels" || !isValidPubkey(parts[2]) {
+		return ""
+	}
+	return parts[2]
+}
+
+// handleUploads dispatches /uploads by method: POST starts an upload, GET
+// presigns a download and DELETE removes an object.
+func handleUploads(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		handleUploadRequest(w, r)
+	case http.MethodGet:
+		handleDownloadRequest(w, r)
+	case http.MethodDelete:
+		handleUploadDelete(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleUploadRequest issues presigned PUT URLs for uploading a file to a channel.
+func handleUploadRequest(w http.ResponseWriter, r *http.Request) {
+	var req UploadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if !isValidChannelID(req.ChannelID) {
+		http.Error(w, "Invalid channelId", http.StatusBadRequest)
+		return
+	}
+	if !allowedMediaType(req.ContentType) {
+		http.Error(w, "contentType must be a supported video or raster image type", http.StatusBadRequest)
+		return
+	}
+	maxSize := uploadMaxBytes()
+	if req.Size <= 0 || req.Size > maxSize {
+		http.Error(w, fmt.Sprintf("size must be between 1 and %d bytes", maxSize), http.StatusBadRequest)
+		return
+	}
+
+	var pubkey string
+	if claims, ok := TokenClaimsFromContext(r.Context()); ok {
+		pubkey = claims.Subject
+	}
+	key := uploadKey(req.ChannelID, pubkey, req.Filename)
+	if !authorizeToken(w, r, OpUpload, key) {
+		return
+	}
+
+	ttl := envDuration("UPLOAD_URL_TTL", 15*time.Minute)
+	resp := UploadResponse{
+		Key:       key,
+		Method:    http.MethodPut,
+		ExpiresAt: time.Now().Add(ttl).Unix(),
+	}
+
+	multipart, canMultipart := blobs.(storage.MultipartStore)
+	if !canMultipart || req.Size <= int64(envInt("UPLOAD_MULTIPART_THRESHOLD", 100<<20)) {
+		url, err := blobs.Presign(http.MethodPut, resp.Key, req.ContentType, ttl)
+		if err != nil {
+			log.Printf("Failed to presign upload for key=%s: %v", resp.Key, err)
+			http.Error(w, "Failed to prepare upload", http.StatusInternalServerError)
+			return
+		}
+		resp.URL = url
+		resp.Headers = map[string]string{"Content-Type": req.ContentType}
+		sendJSONResponse(w, resp)
+		return
+	}
+
+	partSize := int64(uploadPartSize)
+	parts := (req.Size + partSize - 1) / partSize
+	if parts > maxUploadParts {
+		partSize = (req.Size + maxUploadParts - 1) / maxUploadParts
+		parts = (req.Size + partSize - 1) / partSize
+	}
+
+	uploadID, err := multipart.CreateMultipart(r.Context(), resp.Key, req.ContentType)
+	if err != nil {
+		log.Printf("Failed to create multipart upload for key=%s: %v", resp.Key, err)
+		http.Error(w, "Failed to prepare upload", http.StatusInternalServerError)
+		return
+	}
+
+	resp.UploadID = uploadID
+	resp.PartSize = partSize
+	for n := int64(1); n <= parts; n++ {
+		url, err := multipart.PresignPart(resp.Key, uploadID, n, ttl)
+		if err != nil {
+			log.Printf("Failed to presign part %d for key=%s: %v", n, resp.Key, err)
+			http.Error(w, "Failed to prepare upload", http.StatusInternalServerError)
+			return
+		}
+		resp.Parts = append(resp.Parts, UploadPart{PartNumber: n, URL: url})
+	}
+	sendJSONResponse(w, resp)
+}
+
+// handleUploadComplete finishes a multipart upload once all parts are uploaded.
+func handleUploadComplete(w http.ResponseWriter, r *http.Request) {
+	req, multipart, ok := decodeMultipartRequest(w, r)
+	if !ok {
+		return
+	}
+	if len(req.Parts) == 0 {
+		http.Error(w, "parts are required", http.StatusBadRequest)
+		return
+	}
+
+	parts := make([]storage.CompletedPart, 0, len(req.Parts))
+	for _, part := range req.Parts {
+		parts = append(parts, storage.CompletedPart{PartNumber: part.PartNumber, ETag: part.ETag})
+	}
+
+	if err := multipart.CompleteMultipart(r.Context(), req.Key, req.UploadID, parts); err != nil {
+		log.Printf("Failed to complete multipart upload for key=%s: %v", req.Key, err)
+		http.Error(w, "Failed to complete upload", http.StatusBadGateway)
+		return
+	}
+	sendJSONResponse(w, map[string]string{"key": req.Key})
+}
+
+// handleUploadAbort cancels a multipart upload and discards its parts.
+func handleUploadAbort(w http.ResponseWriter, r *http.Request) {
+	req, multipart, ok := decodeMultipartRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if err := multipart.AbortMultipart(r.Context(), req.Key, req.UploadID); err != nil {
+		log.Printf("Failed to abort multipart upload for key=%s: %v", req.Key, err)
+		http.Error(w, "Failed to abort upload", http.StatusBadGateway)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// decodeMultipartRequest parses and validates a complete/abort request,
+// writing the error response itself when it fails.
+func decodeMultipartRequest(w http.ResponseWriter, r *http.Request) (CompleteUploadRequest, storage.MultipartStore, bool) {
+	var req CompleteUploadRequest
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return req, nil, false
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return req, nil, false
+	}
+	if req.Key == "" || req.UploadID == "" {
+		http.Error(w, "key and uploadId are required", http.StatusBadRequest)
+		return req, nil, false
+	}
+	if !authorizeToken(w, r, OpUpload, req.Key) {
+		return req, nil, false
+	}
+	multipart, ok := blobs.(storage.MultipartStore)
+	if !ok {
+		http.Error(w, "Multipart uploads are not supported by this storage backend", http.StatusNotImplemented)
+		return req, nil, false
+	}
+	return req, multipart, true
+}
+
+// handleDownloadRequest presigns a GET URL for the object named by the key
+// query parameter.
+func handleDownloadRequest(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+	if !storage.ValidKey(key) {
+		http.Error(w, "Invalid key", http.StatusBadRequest)
+		return
+	}
+	if !authorizeToken(w, r, OpRead, key) {
+		return
+	}
+
+	ttl := envDuration("UPLOAD_URL_TTL", 15*time.Minute)
+	url, err := blobs.Presign(http.MethodGet, key, "", ttl)
+	if err != nil {
+		log.Printf("Failed to presign download for key=%s: %v", key, err)
+		http.Error(w, "Failed to prepare download", http.StatusInternalServerError)
+		return
+	}
+	sendJSONResponse(w, UploadResponse{
+		Key:       key,
+		Method:    http.MethodGet,
+		URL:       url,
+		ExpiresAt: time.Now().Add(ttl).Unix(),
+	})
+}
+
+// handleUploadDelete deletes the object named by the key query parameter.
+func handleUploadDelete(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+	if !storage.ValidKey(key) {
+		http.Error(w, "Invalid key", http.StatusBadRequest)
+		return
+	}
+	if !authorizeToken(w, r, OpDelete, key) {
+		return
+	}
+
+	if err := blobs.Delete(r.Context(), key); err != nil {
+		log.Printf("Failed to delete key=%s: %v", key, err)
+		http.Error(w, "Failed to delete object", http.StatusBadGateway)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authorizeToken checks that storage is configured and the caller's token
+// permits op on key, writing the error response with the denial reason when
+// it does not.
+func authorizeToken(w http.ResponseWriter, r *http.Request, op, key string) bool {
+	if blobs == nil {
+		http.Error(w, "Storage not configured", http.StatusServiceUnavailable)
+		return false
+	}
+	claims, ok := TokenClaimsFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Forbidden: no token", http.StatusForbidden)
+		return false
+	}
+	if err := claims.Authorize(op, key); err != nil {
+		http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"hub/storage"
+)
+
+func testClaims(subject string) *TokenClaims {
+	claims := &TokenClaims{
+		AllowedBucket: storageBucket(),
+		Ops:           []string{OpRead, OpUpload},
+		KeyPrefix:     "channels/",
+	}
+	claims.Subject = subject
+	return claims
+}
+
+func TestAuthorizeOwnKeysOnly(t *testing.T) {
+	channel := strings.Repeat("a", 64)
+	owner := strings.Repeat("b", 64)
+	other := strings.Repeat("c", 64)
+	claims := testClaims(owner)
+
+	own := uploadKey(channel, owner, "clip.mp4")
+	if err := claims.Authorize(OpUpload, own); err != nil {
+		t.Errorf("Authorize(own key) = %v", err)
+	}
+	if err := claims.Authorize(OpUpload, uploadKey(channel, other, "clip.mp4")); err == nil {
+		t.Error("Authorize allowed uploading to another pubkey's key")
+	}
+	if err := claims.Authorize(OpDelete, own); err == nil {
+		t.Error("Authorize allowed an op the token does not grant")
+	}
+
+	// The owner segment says owner, but the path resolves into other's keys
+	traversal := "channels/" + channel + "/" + owner + "/../" + other + "/x/clip.mp4"
+	if err := claims.Authorize(OpUpload, traversal); err == nil {
+		t.Errorf("Authorize allowed %q", traversal)
+	}
+}
+
+func TestOwnerFromKey(t *testing.T) {
+	channel := strings.Repeat("a", 64)
+	owner := strings.Repeat("b", 64)
+	for key, want := range map[string]string{
+		uploadKey(channel, owner, "clip.mp4"):                                owner,
+		"channels/" + channel + "/" + strings.ToUpper(owner) + "/x/clip.mp4": "",
+		"channels/" + channel + "/npub1xyz/x/clip.mp4":                       "",
+		"channels/" + channel + "/" + owner:                                  "",
+		"blossom/" + owner + "/x/clip.mp4":                                   "",
+	} {
+		if got := ownerFromKey(key); got != want {
+			t.Errorf("ownerFromKey(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestDecodeMultipartRequestRejectsInvalidKey(t *testing.T) {
+	local, err := storage.NewLocal(storage.Config{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := blobs
+	blobs = local
+	defer func() { blobs = previous }()
+
+	channel := strings.Repeat("a", 64)
+	owner := strings.Repeat("b", 64)
+	key := "channels/" + channel + "/" + owner + "/../" + strings.Repeat("c", 64) + "/x/clip.mp4"
+	body := `{"key":"` + key + `","uploadId":"u"}`
+
+	r := httptest.NewRequest(http.MethodPost, "/uploads/complete", strings.NewReader(body))
+	r = r.WithContext(context.WithValue(r.Context(), tokenClaimsKey, testClaims(owner)))
+	w := httptest.NewRecorder()
+	if _, _, ok := decodeMultipartRequest(w, r); ok {
+		t.Fatal("decodeMultipartRequest accepted a key with .. segments")
+	}
+	if w.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want 403", w.Code)
+	}
+}
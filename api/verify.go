@@ -0,0 +1,92 @@
+package api
+
+import (
+	"errors"
+	"expvar"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// rejectedEvents counts relay events dropped by verification, keyed by reason.
+// It is exported with the other counters at /debug/vars on DEBUG_ADDR.
+var rejectedEvents = expvar.NewMap("rejected_events")
+
+// eventRejection is a verification failure with a stable reason for counting.
+type eventRejection struct {
+	reason string
+}
+
+func (e *eventRejection) Error() string {
+	return "event rejected: " + e.reason
+}
+
+// verifyEvent checks that an event's ID matches its content and that it is
+// signed by its pubkey.
+func verifyEvent(ev *nostr.Event) error {
+	if ev.GetID() != ev.ID {
+		return &eventRejection{reason: "bad_id"}
+	}
+	if ok, err := ev.CheckSignature(); err != nil || !ok {
+		return &eventRejection{reason: "bad_signature"}
+	}
+	return nil
+}
+
+// verifyChannelEvent checks that ev is a valid, signed kind 42 message that
+// belongs to channelID.
+func verifyChannelEvent(ev *nostr.Event, channelID string) error {
+	if ev.Kind != nostr.KindChannelMessage {
+		return &eventRejection{reason: "wrong_kind"}
+	}
+	if !referencesEvent(ev.Tags, channelID) {
+		return &eventRejection{reason: "wrong_channel"}
+	}
+	return verifyEvent(ev)
+}
+
+// verifyChannelCreation checks that ev is the valid, signed kind 40 event
+// that created channelID.
+func verifyChannelCreation(ev *nostr.Event, channelID string) error {
+	if ev.Kind != nostr.KindChannelCreation {
+		return &eventRejection{reason: "wrong_kind"}
+	}
+	if ev.ID != channelID {
+		return &eventRejection{reason: "wrong_channel"}
+	}
+	return verifyEvent(ev)
+}
+
+// verifyChannelEvents returns the events that pass verifyChannelEvent,
+// counting the others. events is left untouched.
+func verifyChannelEvents(events []nostr.Event, channelID string) []nostr.Event {
+	verified := make([]nostr.Event, 0, len(events))
+	for i := range events {
+		if err := verifyChannelEvent(&events[i], channelID); err != nil {
+			countRejection(err)
+			continue
+		}
+		verified = append(verified, events[i])
+	}
+	return verified
+}
+
+// countRejection records a dropped event. Drops are only counted, not
+// logged, since the events come from anyone who can write to a relay.
+func countRejection(err error) {
+	reason := "invalid"
+	var rejection *eventRejection
+	if errors.As(err, &rejection) {
+		reason = rejection.reason
+	}
+	rejectedEvents.Add(reason, 1)
+}
+
+// referencesEvent reports whether tags contain an "e" tag pointing at id.
+func referencesEvent(tags nostr.Tags, id string) bool {
+	for _, tag := range tags {
+		if len(tag) >= 2 && tag[0] == "e" && tag[1] == id {
+			return true
+		}
+	}
+	return false
+}
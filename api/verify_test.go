@@ -0,0 +1,56 @@
+package api
+
+import (
+	"errors"
+	"expvar"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func rejections(reason string) int64 {
+	if v, ok := rejectedEvents.Get(reason).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+func TestVerifyChannelEventRejects(t *testing.T) {
+	channelID := strings.Repeat("a", 64)
+	for want, ev := range map[string]nostr.Event{
+		"wrong_kind":    {ID: "x", Kind: nostr.KindChannelCreation, Tags: nostr.Tags{{"e", channelID}}},
+		"wrong_channel": {ID: "x", Kind: nostr.KindChannelMessage, Tags: nostr.Tags{{"e", strings.Repeat("b", 64)}}},
+		"bad_id":        {ID: "x", Kind: nostr.KindChannelMessage, Tags: nostr.Tags{{"e", channelID}}},
+	} {
+		var rejection *eventRejection
+		if err := verifyChannelEvent(&ev, channelID); !errors.As(err, &rejection) || rejection.reason != want {
+			t.Errorf("verifyChannelEvent = %v, want %s", err, want)
+		}
+	}
+}
+
+func TestVerifyChannelEventsLeavesInputIntact(t *testing.T) {
+	channelID := strings.Repeat("a", 64)
+	events := []nostr.Event{
+		{ID: "1", Kind: nostr.KindChannelCreation},
+		{ID: "2", Kind: nostr.KindChannelMessage},
+		{ID: "3", Kind: nostr.KindChannelMessage, Tags: nostr.Tags{{"e", channelID}}},
+	}
+	before := rejections("wrong_kind") + rejections("wrong_channel") + rejections("bad_id")
+
+	verified := verifyChannelEvents(events, channelID)
+	if len(verified) != 0 {
+		t.Errorf("verified %d forged events", len(verified))
+	}
+	// The result must not share the caller's backing array
+	_ = append(verified, nostr.Event{ID: "appended"})
+	for i, ev := range events {
+		if want := string(rune('1' + i)); ev.ID != want {
+			t.Errorf("events[%d].ID = %q after verification, want %q", i, ev.ID, want)
+		}
+	}
+	if after := rejections("wrong_kind") + rejections("wrong_channel") + rejections("bad_id"); after-before != 3 {
+		t.Errorf("counted %d rejections, want 3", after-before)
+	}
+}
@@ -0,0 +1,189 @@
+package api
+
+import (
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Video is a video attached to a channel message.
+type Video struct {
+	EventID   string          `json:"eventId"`
+	URL       string          `json:"url"`
+	MimeType  string          `json:"mimeType,omitempty"`
+	Width     int             `json:"width,omitempty"`
+	Height    int             `json:"height,omitempty"`
+	Duration  float64         `json:"duration,omitempty"`
+	Size      int64           `json:"size,omitempty"`
+	Thumbnail string          `json:"thumbnail,omitempty"`
+	SHA256    string          `json:"sha256,omitempty"`
+	Author    string          `json:"author"`
+	CreatedAt nostr.Timestamp `json:"createdAt"`
+	Caption   string          `json:"caption,omitempty"`
+}
+
+// videoMimeTypes maps video file extensions to their MIME type.
+var videoMimeTypes = map[string]string{
+	".mp4":  "video/mp4",
+	".m4v":  "video/x-m4v",
+	".mov":  "video/quicktime",
+	".webm": "video/webm",
+	".mkv":  "video/x-matroska",
+	".ogv":  "video/ogg",
+	".m3u8": "application/vnd.apple.mpegurl",
+}
+
+var urlPattern = regexp.MustCompile(`https?://[^\s<>"']+`)
+
+// videosFromEvents extracts the videos attached to each message, skipping
+// messages without any.
+func videosFromEvents(events []nostr.Event) []Video {
+	videos := []Video{}
+	for i := range events {
+		videos = append(videos, videosFromEvent(&events[i])...)
+	}
+	return videos
+}
+
+// videosFromEvent returns the videos in a message. Attachments are described
+// by NIP-92 "imeta" tags, NIP-94 style "url"/"m"/... tags, or bare URLs in the
+// content; the first description of a URL wins.
+func videosFromEvent(ev *nostr.Event) []Video {
+	var attachments []map[string]string
+	for _, tag := range ev.Tags {
+		if len(tag) > 1 && tag[0] == "imeta" {
+			attachments = append(attachments, parseImeta(tag[1:]))
+		}
+	}
+	if fields := topLevelMedia(ev.Tags); fields != nil {
+		attachments = append(attachments, fields)
+	}
+	for _, u := range urlPattern.FindAllString(ev.Content, -1) {
+		attachments = append(attachments, map[string]string{"url": strings.TrimRight(u, ".,;:!?)")})
+	}
+
+	caption := strings.Join(strings.Fields(urlPattern.ReplaceAllString(ev.Content, "")), " ")
+
+	var videos []Video
+	seen := make(map[string]bool)
+	for _, fields := range attachments {
+		u := fields["url"]
+		if u == "" || seen[u] {
+			continue
+		}
+		seen[u] = true
+
+		video, ok := videoFromFields(fields)
+		if !ok {
+			continue
+		}
+		video.EventID = ev.ID
+		video.Author = ev.PubKey
+		video.CreatedAt = ev.CreatedAt
+		video.Caption = caption
+		videos = append(videos, video)
+	}
+	return videos
+}
+
+// parseImeta turns NIP-92 "key value" entries into a map. Repeated keys keep
+// their first value.
+func parseImeta(entries []string) map[string]string {
+	fields := make(map[string]string)
+	for _, entry := range entries {
+		key, value, ok := strings.Cut(strings.TrimSpace(entry), " ")
+		if !ok {
+			continue
+		}
+		if _, exists := fields[key]; !exists {
+			fields[key] = strings.TrimSpace(value)
+		}
+	}
+	return fields
+}
+
+// topLevelMedia reads NIP-94 style file tags ("url", "m", "x", ...) placed
+// directly on the event. It returns nil if there is no "url" tag.
+func topLevelMedia(tags nostr.Tags) map[string]string {
+	var fields map[string]string
+	for _, tag := range tags {
+		if len(tag) < 2 {
+			continue
+		}
+		switch tag[0] {
+		case "url", "m", "x", "dim", "size", "duration", "thumb", "image":
+			if fields == nil {
+				fields = make(map[string]string)
+			}
+			if _, exists := fields[tag[0]]; !exists {
+				fields[tag[0]] = tag[1]
+			}
+		}
+	}
+	if fields["url"] == "" {
+		return nil
+	}
+	return fields
+}
+
+// videoFromFields builds a Video from attachment metadata, reporting false if
+// the attachment is not a video.
+func videoFromFields(fields map[string]string) (Video, bool) {
+	video := Video{
+		URL:      fields["url"],
+		MimeType: strings.ToLower(fields["m"]),
+		SHA256:   strings.ToLower(fields["x"]),
+	}
+	if !isWebURL(video.URL) {
+		return Video{}, false
+	}
+
+	if video.MimeType == "" {
+		video.MimeType = mimeTypeFromURL(video.URL)
+	}
+	if !strings.HasPrefix(video.MimeType, "video/") && video.MimeType != videoMimeTypes[".m3u8"] {
+		return Video{}, false
+	}
+
+	if w, h, ok := strings.Cut(fields["dim"], "x"); ok {
+		video.Width, _ = strconv.Atoi(w)
+		video.Height, _ = strconv.Atoi(h)
+	}
+	if d, err := parseFiniteFloat(fields["duration"]); err == nil && d > 0 {
+		video.Duration = d
+	}
+	if size, err := strconv.ParseInt(fields["size"], 10, 64); err == nil && size > 0 {
+		video.Size = size
+	}
+
+	for _, thumb := range []string{fields["thumb"], fields["image"]} {
+		if isWebURL(thumb) {
+			video.Thumbnail = thumb
+			break
+		}
+	}
+	return video, true
+}
+
+// isWebURL reports whether raw is an absolute http or https URL, the only
+// kind clients may load media from.
+func isWebURL(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	return u.Scheme == "http" || u.Scheme == "https"
+}
+
+// mimeTypeFromURL guesses a video MIME type from the URL's file extension.
+func mimeTypeFromURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	return videoMimeTypes[strings.ToLower(path.Ext(u.Path))]
+}
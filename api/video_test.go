@@ -0,0 +1,70 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestVideosFromEventImeta(t *testing.T) {
+	ev := &nostr.Event{
+		ID:      "event",
+		Content: "kickflip https://cdn.example.com/clip.mp4",
+		Tags: nostr.Tags{{
+			"imeta",
+			"url https://cdn.example.com/clip.mp4",
+			"m video/mp4",
+			"dim 1920x1080",
+			"duration 12.5",
+		}},
+	}
+	videos := videosFromEvent(ev)
+	if len(videos) != 1 {
+		t.Fatalf("got %d videos, want 1", len(videos))
+	}
+	v := videos[0]
+	if v.Width != 1920 || v.Height != 1080 || v.Duration != 12.5 || v.Caption != "kickflip" {
+		t.Errorf("video = %+v", v)
+	}
+}
+
+func TestVideosFromEventRejectsNonFiniteDuration(t *testing.T) {
+	for _, duration := range []string{"NaN", "Inf", "-Inf", "1e400"} {
+		ev := &nostr.Event{Tags: nostr.Tags{
+			{"url", "https://cdn.example.com/clip.webm"},
+			{"duration", duration},
+		}}
+		videos := videosFromEvent(ev)
+		if len(videos) != 1 {
+			t.Fatalf("duration %s: got %d videos, want 1", duration, len(videos))
+		}
+		if videos[0].Duration != 0 {
+			t.Errorf("duration %s parsed as %v, want 0", duration, videos[0].Duration)
+		}
+		if _, err := json.Marshal(videos); err != nil {
+			t.Errorf("duration %s: %v", duration, err)
+		}
+	}
+}
+
+func TestVideosFromEventRejectsNonWebURLs(t *testing.T) {
+	ev := &nostr.Event{Tags: nostr.Tags{
+		{"imeta", "url javascript:alert(1)", "m video/mp4"},
+		{"imeta", "url data:video/mp4;base64,AAAA", "m video/mp4"},
+		{"imeta", "url //cdn.example.com/relative.mp4", "m video/mp4"},
+		{"imeta", "url https://cdn.example.com/clip.mp4", "m video/mp4", "thumb javascript:alert(1)", "image https://cdn.example.com/clip.jpg"},
+	}}
+	videos := videosFromEvent(ev)
+	if len(videos) != 1 {
+		t.Fatalf("got %d videos, want only the https one: %+v", len(videos), videos)
+	}
+	if videos[0].Thumbnail != "https://cdn.example.com/clip.jpg" {
+		t.Errorf("thumbnail = %q, want the https image", videos[0].Thumbnail)
+	}
+
+	ev = &nostr.Event{Tags: nostr.Tags{{"url", "javascript:alert(1)"}, {"m", "video/mp4"}}}
+	if videos := videosFromEvent(ev); len(videos) != 0 {
+		t.Errorf("NIP-94 javascript: URL accepted: %+v", videos)
+	}
+}
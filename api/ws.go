@@ -1,16 +1,145 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
 	"log"
+	"math"
 	"net/http"
+	"sort"
+	"time"
 
+	"hub/broadcast"
+
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
-// Message struct for JSON encoding
-type Message struct {
-	Text string `json:"text"`
+const (
+	// wsWriteWait is how long a single write may take.
+	wsWriteWait = 10 * time.Second
+	// wsPongWait is how long a client may stay silent before it is dropped.
+	wsPongWait = 60 * time.Second
+	// wsPingPeriod must be shorter than wsPongWait so pongs arrive in time.
+	wsPingPeriod = wsPongWait * 9 / 10
+	// wsMaxMessageSize bounds incoming messages.
+	wsMaxMessageSize = 64 << 10
+	// wsSendBuffer is how many outgoing messages a client may fall behind by
+	// before it is evicted.
+	wsSendBuffer = 256
+	// wsMaxRooms bounds how many rooms one client may join.
+	wsMaxRooms = 32
+	// wsReplayHeadroom is how much of a client's send queue a join replay
+	// leaves free for live frames.
+	wsReplayHeadroom = 32
+)
+
+// defaultWSFrameRate and defaultWSFrameBurst bound how many message and
+// event frames one client may send, per minute and at once.
+const (
+	defaultWSFrameRate  = 60
+	defaultWSFrameBurst = 10
+)
+
+// wsProtocolVersion is the envelope version spoken on /ws.
+const wsProtocolVersion = 1
+
+// Frame types on /ws.
+const (
+	msgJoin      = "join"
+	msgLeave     = "leave"
+	msgMessage   = "message"
+	msgRooms     = "rooms"
+	msgEvent     = "event"
+	msgPresence  = "presence"
+	msgHeartbeat = "heartbeat"
+	msgAck       = "ack"
+	msgError     = "error"
+)
+
+var (
+	wsClients = expvar.NewInt("ws_clients")
+	wsEvicted = expvar.NewInt("ws_evicted")
+	wsDropped = expvar.NewInt("ws_broadcast_dropped")
+)
+
+// Envelope is a frame on /ws. Clients send requests such as "join",
+// "leave", "rooms" and "message"; each is answered with an "ack" carrying
+// the request's ID and the handler's result, or an "error" frame carrying
+// the ID and what went wrong. The hub pushes room traffic as "message" and
+// "event" frames whose ID identifies the message, so clients can drop
+// frames they have already seen.
+type Envelope struct {
+	V       int             `json:"v"`
+	Type    string          `json:"type"`
+	ID      string          `json:"id,omitempty"`
+	Room    string          `json:"room,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+	Error   *WSError        `json:"error,omitempty"`
+}
+
+// WSError is the error of an "error" frame. Handlers return one to pick the
+// code; other errors are reported as "bad_request".
+type WSError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *WSError) Error() string {
+	return e.Message
+}
+
+// ChatMessage is the payload of "message" frames. Pubkey and CreatedAt (Unix
+// seconds) are set by the hub on relayed messages, Pubkey only for
+// authenticated clients.
+type ChatMessage struct {
+	Text      string `json:"text"`
+	Pubkey    string `json:"pubkey,omitempty"`
+	CreatedAt int64  `json:"createdAt,omitempty"`
+}
+
+// RoomInfo is a room and how many clients connected to this instance are in
+// it. Frames are shared between instances but membership is not, so behind a
+// load balancer the count is a lower bound.
+type RoomInfo struct {
+	Room    string `json:"room"`
+	Members int    `json:"members"`
+}
+
+// WSHandler handles one type of client frame. A non-nil result is sent back
+// as the ack's payload; an error is sent back as an "error" frame.
+type WSHandler func(c *Client, env Envelope) (interface{}, error)
+
+// wsHandlers maps frame types to their handlers.
+var wsHandlers = make(map[string]WSHandler)
+
+// HandleWS registers the handler for a client frame type. It is meant to be
+// called from init functions.
+func HandleWS(msgType string, handler WSHandler) {
+	wsHandlers[msgType] = handler
+}
+
+func init() {
+	HandleWS(msgJoin, handleJoin)
+	HandleWS(msgLeave, handleLeave)
+	HandleWS(msgRooms, handleRooms)
+	HandleWS(msgMessage, handleMessage)
+}
+
+// newFrame encodes a server frame with payload as its payload.
+func newFrame(msgType, id, room string, payload interface{}) ([]byte, error) {
+	env := Envelope{V: wsProtocolVersion, Type: msgType, ID: id, Room: room}
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		env.Payload = data
+	}
+	return json.Marshal(env)
 }
 
 // WebSocket upgrader
@@ -20,67 +149,620 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
-// WebSocket clients map
-var clients = make(map[*websocket.Conn]bool)
+// wsHub fans messages out to the connected WebSocket clients.
+var wsHub *Hub
+
+var (
+	errHubStopped = &WSError{Code: "unavailable", Message: "server is shutting down"}
+	errNotMember  = &WSError{Code: "not_member", Message: "join the room before sending to it"}
+)
+
+// Client is a WebSocket connection registered with a Hub. Only its
+// writePump writes to conn, only the hub's Run goroutine touches send and
+// rooms, and only its readPump touches frames. pubkey is set when the
+// client connected with an access token.
+type Client struct {
+	hub    *Hub
+	conn   *websocket.Conn
+	send   chan []byte
+	rooms  map[string]bool
+	pubkey string
+	frames tokenBucket // budget for frames that reach other clients or relays
+}
+
+// roomMessage is a frame for a single client if to is set, otherwise for
+// the members of room, or for everyone if room is empty.
+type roomMessage struct {
+	room string
+	data []byte
+	to   *Client
+}
+
+// Hub owns the set of connected clients and rooms. All changes happen on the
+// Run goroutine, which never blocks on a client: one whose send queue is
+// full is evicted instead. Room frames reach the other instances through
+// the broadcast backend.
+//
+// The hooks, if set before Run, are called on the Run goroutine: RoomOpened
+// when a client becomes a room's first member, RoomClosed when the room loses
+// its last one, MemberJoined and MemberLeft when a client joins or leaves a
+// room. They must not block or call back into the hub. History, if set, records the
+// frames delivered to rooms and replays them on join.
+//
+// Each client may send message and event frames at WS_FRAME_RATE per
+// minute, in bursts of WS_FRAME_BURST; a rate of 0 disables the limit.
+type Hub struct {
+	RoomOpened   func(client *Client, room string)
+	RoomClosed   func(room string)
+	MemberJoined func(client *Client, room string)
+	MemberLeft   func(client *Client, room string)
+	History      *History
+
+	clients    map[*Client]bool
+	rooms      map[string]map[*Client]bool
+	broadcast  chan roomMessage
+	register   chan *Client
+	unregister chan *Client
+	calls      chan func()
+	done       chan struct{}
+	backend    broadcast.Broadcaster
+	frameRate  rateBudget
+}
+
+// NewHub creates a hub that shares rooms with other instances through
+// backend, or only within this process if backend is nil. Call Run to start
+// it.
+func NewHub(backend broadcast.Broadcaster) *Hub {
+	if backend == nil {
+		backend = broadcast.NewMemory()
+	}
+	return &Hub{
+		clients:    make(map[*Client]bool),
+		rooms:      make(map[string]map[*Client]bool),
+		broadcast:  make(chan roomMessage, wsSendBuffer),
+		register:   make(chan *Client),
+		unregister: make(chan *Client),
+		calls:      make(chan func()),
+		done:       make(chan struct{}),
+		backend:    backend,
+		frameRate: rateBudget{
+			perSecond: float64(envInt("WS_FRAME_RATE", defaultWSFrameRate)) / 60,
+			burst:     float64(envInt("WS_FRAME_BURST", defaultWSFrameBurst)),
+		},
+	}
+}
+
+// Run serves registrations and broadcasts until ctx is cancelled, then
+// disconnects every client.
+func (h *Hub) Run(ctx context.Context) {
+	defer close(h.done)
+	if err := h.backend.Subscribe(ctx, h.BroadcastLocal); err != nil {
+		log.Printf("⚠️ WebSocket broadcasts limited to this instance: %v", err)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			for client := range h.clients {
+				h.remove(client)
+			}
+			return
+
+		case client := <-h.register:
+			h.clients[client] = true
+			wsClients.Set(int64(len(h.clients)))
+
+		case client := <-h.unregister:
+			if h.clients[client] {
+				h.remove(client)
+			}
+
+		case call := <-h.calls:
+			call()
+
+		case msg := <-h.broadcast:
+			if msg.to != nil {
+				h.deliver(msg.to, msg.data)
+				continue
+			}
+			if msg.room == "" {
+				for client := range h.clients {
+					h.deliver(client, msg.data)
+				}
+				continue
+			}
+			for client := range h.rooms[msg.room] {
+				h.deliver(client, msg.data)
+			}
+		}
+	}
+}
+
+// joinRoom adds client to room.
+func (h *Hub) joinRoom(client *Client, room string) error {
+	if !h.clients[client] {
+		return errHubStopped
+	}
+	if client.rooms[room] {
+		return nil
+	}
+	if len(client.rooms) >= wsMaxRooms {
+		return &WSError{Code: "too_many_rooms", Message: "too many rooms"}
+	}
+	if h.rooms[room] == nil {
+		h.rooms[room] = make(map[*Client]bool)
+		if h.RoomOpened != nil {
+			h.RoomOpened(client, room)
+		}
+	}
+	h.rooms[room][client] = true
+	client.rooms[room] = true
+	if h.MemberJoined != nil {
+		h.MemberJoined(client, room)
+	}
+	return nil
+}
+
+// leaveRoom removes client from room, dropping the room once it is empty.
+func (h *Hub) leaveRoom(client *Client, room string) {
+	if !client.rooms[room] {
+		return
+	}
+	delete(client.rooms, room)
+	if h.MemberLeft != nil {
+		h.MemberLeft(client, room)
+	}
+	if members := h.rooms[room]; members != nil {
+		delete(members, client)
+		if len(members) == 0 {
+			delete(h.rooms, room)
+			if h.RoomClosed != nil {
+				h.RoomClosed(room)
+			}
+		}
+	}
+}
+
+func (h *Hub) roomInfo(room string) RoomInfo {
+	return RoomInfo{Room: room, Members: len(h.rooms[room])}
+}
+
+// roomList returns every room, busiest first.
+func (h *Hub) roomList() []RoomInfo {
+	rooms := make([]RoomInfo, 0, len(h.rooms))
+	for room := range h.rooms {
+		rooms = append(rooms, h.roomInfo(room))
+	}
+	sort.Slice(rooms, func(i, j int) bool {
+		if rooms[i].Members != rooms[j].Members {
+			return rooms[i].Members > rooms[j].Members
+		}
+		return rooms[i].Room < rooms[j].Room
+	})
+	return rooms
+}
+
+// deliver queues data for client, evicting it if its queue is full.
+func (h *Hub) deliver(client *Client, data []byte) {
+	if !h.clients[client] {
+		return
+	}
+	select {
+	case client.send <- data:
+	default:
+		log.Println("Evicting slow WebSocket client")
+		wsEvicted.Add(1)
+		h.remove(client)
+	}
+}
+
+// remove drops a client from the hub and its rooms and closes its send
+// queue, which makes its writePump close the connection.
+func (h *Hub) remove(client *Client) {
+	for room := range client.rooms {
+		h.leaveRoom(client, room)
+	}
+	delete(h.clients, client)
+	close(client.send)
+	wsClients.Set(int64(len(h.clients)))
+}
+
+// Register adds a client, reporting false if the hub has stopped.
+func (h *Hub) Register(client *Client) bool {
+	select {
+	case h.register <- client:
+		return true
+	case <-h.done:
+		return false
+	}
+}
+
+// Unregister removes a client if it is still registered.
+func (h *Hub) Unregister(client *Client) {
+	select {
+	case h.unregister <- client:
+	case <-h.done:
+	}
+}
+
+// do runs fn on the Run goroutine and waits for it to finish, reporting
+// false if the hub has stopped.
+func (h *Hub) do(fn func()) bool {
+	finished := make(chan struct{})
+	select {
+	case h.calls <- func() { fn(); close(finished) }:
+		<-finished
+		return true
+	case <-h.done:
+		return false
+	}
+}
+
+// Join adds client to room, sends it the room's history matching replay
+// and returns the room's new size. Frames recorded before the join are
+// replayed and later ones are delivered live, so none are missed, though
+// one may arrive twice. The replay is cut to the newest frames that fit in
+// the client's send queue, less wsReplayHeadroom, so joining never gets a
+// client evicted.
+func (h *Hub) Join(client *Client, room string, replay HistoryQuery) (RoomInfo, error) {
+	var info RoomInfo
+	var err error = errHubStopped
+	h.do(func() {
+		if err = h.joinRoom(client, room); err != nil {
+			return
+		}
+		info = h.roomInfo(room)
+		if h.History != nil && replay.Limit >= 0 {
+			free := cap(client.send) - len(client.send) - wsReplayHeadroom
+			if free <= 0 {
+				return
+			}
+			if replay.Limit == 0 || replay.Limit > free {
+				replay.Limit = free
+			}
+			for _, frame := range h.History.Replay(room, replay) {
+				h.deliver(client, frame)
+			}
+		}
+	})
+	return info, err
+}
+
+// Leave removes client from room and returns the room's new size.
+func (h *Hub) Leave(client *Client, room string) RoomInfo {
+	var info RoomInfo
+	h.do(func() {
+		h.leaveRoom(client, room)
+		info = h.roomInfo(room)
+	})
+	return info
+}
+
+// IsMember reports whether client is in room.
+func (h *Hub) IsMember(client *Client, room string) bool {
+	var member bool
+	h.do(func() {
+		member = h.rooms[room][client]
+	})
+	return member
+}
+
+// Rooms returns the open rooms and their member counts.
+func (h *Hub) Rooms() []RoomInfo {
+	var rooms []RoomInfo
+	h.do(func() {
+		rooms = h.roomList()
+	})
+	return rooms
+}
+
+// Shared reports whether the hub's rooms span several instances, in which
+// case its member counts only cover the clients connected to this one.
+func (h *Hub) Shared() bool {
+	_, local := h.backend.(*broadcast.Memory)
+	return !local
+}
+
+// Broadcast queues data for every member of room on every instance.
+func (h *Hub) Broadcast(room string, data []byte) {
+	if err := h.backend.Publish(room, data); err != nil {
+		log.Println("Error broadcasting to other instances:", err)
+		wsDropped.Add(1)
+	}
+}
+
+// BroadcastLocal queues data for the members of room connected to this
+// instance, recording it in the room's history.
+func (h *Hub) BroadcastLocal(room string, data []byte) {
+	if h.History != nil && room != "" {
+		h.History.Record(room, data)
+	}
+	h.send(roomMessage{room: room, data: data})
+}
 
-// Broadcast channel to send messages
-var broadcast = make(chan Message)
+func (h *Hub) send(msg roomMessage) {
+	select {
+	case h.broadcast <- msg:
+	case <-h.done:
+	}
+}
 
-// InitWebSocket starts the message broadcasting loop in a separate goroutine
-func InitWebSocket() {
-	go handleMessages()
+// InitWebSocket starts the hub on the configured broadcast backend with
+// history for replay, bridges its channel rooms to pool's relays and tracks
+// who is present in its rooms. It stops, disconnecting every client, when
+// ctx is cancelled.
+func InitWebSocket(ctx context.Context, pool *RelayPool, history *History) error {
+	backend, err := broadcast.New(broadcastConfig())
+	if err != nil {
+		return err
+	}
+
+	wsHub = NewHub(backend)
+	wsHub.History = history
+	bridge = NewChannelBridge(ctx, pool, wsHub)
+	presence = NewPresence(wsHub)
+	go presence.Run(ctx)
+	go wsHub.Run(ctx)
+	return nil
 }
 
-// HandleWebSocket handles WebSocket connections.
+// HandleWebSocket handles WebSocket connections. Clients may authenticate
+// with an access token in the token query parameter, since browsers cannot
+// set headers on WebSocket requests.
 func HandleWebSocket(w http.ResponseWriter, r *http.Request) {
+	var pubkey string
+	if token := r.URL.Query().Get("token"); token != "" {
+		claims, err := verifyAccessToken(token)
+		if err != nil {
+			http.Error(w, "Unauthorized: "+err.Error(), http.StatusUnauthorized)
+			return
+		}
+		pubkey = claims.Subject
+	}
+
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println("Error upgrading to WebSocket:", err)
 		return
 	}
-	defer conn.Close()
 
-	clients[conn] = true
+	client := &Client{
+		hub:    wsHub,
+		conn:   conn,
+		send:   make(chan []byte, wsSendBuffer),
+		rooms:  make(map[string]bool),
+		pubkey: pubkey,
+	}
+	if !wsHub.Register(client) {
+		conn.Close()
+		return
+	}
 	log.Println("New WebSocket client connected")
 
-	// Listen for messages
+	go client.writePump()
+	client.readPump()
+}
+
+// readPump handles messages from the connection until it fails or goes quiet
+// for longer than wsPongWait.
+func (c *Client) readPump() {
+	defer func() {
+		c.hub.Unregister(c)
+		c.conn.Close()
+	}()
+
+	c.conn.SetReadLimit(wsMaxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
 	for {
-		_, msg, err := conn.ReadMessage()
+		_, msg, err := c.conn.ReadMessage()
 		if err != nil {
-			log.Println("WebSocket error:", err)
-			delete(clients, conn)
-			break
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				log.Println("WebSocket error:", err)
+			}
+			return
 		}
 
-		log.Printf("Received: %s\n", msg)
+		c.handle(msg)
+	}
+}
 
-		// Ensure valid JSON before broadcasting
-		var receivedMsg Message
-		if err := json.Unmarshal(msg, &receivedMsg); err != nil {
-			log.Println("Invalid JSON received:", err)
-			continue
-		}
+// handle decodes one frame from the client, runs its handler and answers
+// with an ack or an error frame.
+func (c *Client) handle(data []byte) {
+	var env Envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		c.replyError("", "", &WSError{Code: "invalid_json", Message: err.Error()})
+		return
+	}
+	if env.V != 0 && env.V != wsProtocolVersion {
+		c.replyError(env.ID, env.Room, &WSError{Code: "unsupported_version", Message: fmt.Sprintf("protocol version %d is not supported", env.V)})
+		return
+	}
 
-		// Send structured message to the broadcast channel
-		broadcast <- receivedMsg
+	handler, ok := wsHandlers[env.Type]
+	if !ok {
+		c.replyError(env.ID, env.Room, &WSError{Code: "unknown_type", Message: fmt.Sprintf("unknown frame type %q", env.Type)})
+		return
+	}
+	result, err := handler(c, env)
+	if err != nil {
+		c.replyError(env.ID, env.Room, err)
+		return
 	}
+	c.reply(msgAck, env.ID, env.Room, result)
 }
 
-// handleMessages listens for messages on the broadcast channel and sends them to all clients
-func handleMessages() {
-	for {
-		msg := <-broadcast
-		jsonMsg, err := json.Marshal(msg) // Ensure outgoing message is JSON
-		if err != nil {
-			log.Println("Error marshalling JSON:", err)
-			continue
+// reply sends a frame to this client only, through the hub so the send
+// queue is never written after the hub closes it.
+func (c *Client) reply(msgType, id, room string, payload interface{}) {
+	data, err := newFrame(msgType, id, room, payload)
+	if err != nil {
+		log.Println("Error marshalling JSON:", err)
+		return
+	}
+	c.hub.send(roomMessage{data: data, to: c})
+}
+
+// replyError sends an error frame answering request id.
+func (c *Client) replyError(id, room string, err error) {
+	var wsErr *WSError
+	if !errors.As(err, &wsErr) {
+		wsErr = &WSError{Code: "bad_request", Message: err.Error()}
+	}
+	data, marshalErr := json.Marshal(Envelope{V: wsProtocolVersion, Type: msgError, ID: id, Room: room, Error: wsErr})
+	if marshalErr != nil {
+		log.Println("Error marshalling JSON:", marshalErr)
+		return
+	}
+	c.hub.send(roomMessage{data: data, to: c})
+}
+
+// frameRoom resolves the room a frame is addressed to.
+func frameRoom(env Envelope) (string, error) {
+	room, err := resolveRoom(env.Room)
+	if err != nil {
+		return "", &WSError{Code: "invalid_room", Message: err.Error()}
+	}
+	return room, nil
+}
+
+// handleJoin adds the client to a room and replays the history asked for by
+// the HistoryQuery payload; the ack carries the room's size on this
+// instance.
+func handleJoin(c *Client, env Envelope) (interface{}, error) {
+	room, err := frameRoom(env)
+	if err != nil {
+		return nil, err
+	}
+	var replay HistoryQuery
+	if len(env.Payload) > 0 {
+		if err := json.Unmarshal(env.Payload, &replay); err != nil {
+			return nil, &WSError{Code: "invalid_payload", Message: "payload must be {\"since\": ..., \"limit\": ...}"}
 		}
+	}
+	return c.hub.Join(c, room, replay)
+}
+
+// handleLeave removes the client from a room; the ack carries the room's
+// size on this instance.
+func handleLeave(c *Client, env Envelope) (interface{}, error) {
+	room, err := frameRoom(env)
+	if err != nil {
+		return nil, err
+	}
+	return c.hub.Leave(c, room), nil
+}
+
+// handleRooms lists the rooms open on this instance, busiest first.
+func handleRooms(c *Client, env Envelope) (interface{}, error) {
+	return c.hub.Rooms(), nil
+}
+
+// handleMessage relays a ChatMessage to the members of a room the client
+// has joined. In channel rooms the bridge may publish it as a kind-42 event
+// instead.
+func handleMessage(c *Client, env Envelope) (interface{}, error) {
+	room, err := frameRoom(env)
+	if err != nil {
+		return nil, err
+	}
+	var msg ChatMessage
+	if err := json.Unmarshal(env.Payload, &msg); err != nil || msg.Text == "" {
+		return nil, &WSError{Code: "invalid_payload", Message: "payload must be {\"text\": ...}"}
+	}
+	if !c.hub.IsMember(c, room) {
+		return nil, errNotMember
+	}
+	if err := c.allowFrame(); err != nil {
+		return nil, err
+	}
 
-		for client := range clients {
-			if err := client.WriteMessage(websocket.TextMessage, jsonMsg); err != nil {
+	ev, err := bridge.sign(c.pubkey, room, msg.Text)
+	if err != nil {
+		return nil, err
+	}
+	if ev != nil {
+		bridge.Publish(room, ev)
+		return nil, nil
+	}
+
+	data, err := newFrame(msgMessage, uuid.New().String(), room, ChatMessage{
+		Text:      msg.Text,
+		Pubkey:    c.pubkey,
+		CreatedAt: time.Now().Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	c.hub.Broadcast(room, data)
+	return nil, nil
+}
+
+// allowFrame takes a token from the client's frame budget, returning a
+// rate_limited error when it is spent.
+func (c *Client) allowFrame() error {
+	budget := c.hub.frameRate
+	if budget.perSecond <= 0 {
+		return nil
+	}
+	ok, wait := c.frames.take(budget, time.Now())
+	if ok {
+		return nil
+	}
+	rateLimited.Add("ws", 1)
+	return &WSError{Code: "rate_limited", Message: fmt.Sprintf("too many messages, retry in %ds", int(math.Ceil(wait.Seconds())))}
+}
+
+// resolveRoom maps a room name to its key: a NIP-28 channel ID as is, or a
+// lead ID, which shares its channel's room when it has one.
+func resolveRoom(name string) (string, error) {
+	if isValidChannelID(name) {
+		return name, nil
+	}
+	id, err := uuid.Parse(name)
+	if err != nil {
+		return "", errors.New("room must be a channel ID or lead ID")
+	}
+	lead, err := leads.Get(id)
+	if err != nil {
+		return "", err
+	}
+	if lead.ChannelID != "" {
+		return lead.ChannelID, nil
+	}
+	return lead.ID.String(), nil
+}
+
+// writePump writes queued messages and pings to the connection. It is the
+// only goroutine that writes to it.
+func (c *Client) writePump() {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case msg, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if !ok {
+				// The hub closed the queue
+				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := c.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
 				log.Println("Error broadcasting:", err)
-				client.Close()
-				delete(clients, client)
+				return
+			}
+
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
 			}
 		}
 	}
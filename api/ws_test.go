@@ -0,0 +1,101 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestClientFrameBudget(t *testing.T) {
+	t.Setenv("WS_FRAME_RATE", "60")
+	t.Setenv("WS_FRAME_BURST", "2")
+	c := &Client{hub: NewHub(nil)}
+
+	for i := 0; i < 2; i++ {
+		if err := c.allowFrame(); err != nil {
+			t.Fatalf("frame %d within the burst: %v", i, err)
+		}
+	}
+	var wsErr *WSError
+	if err := c.allowFrame(); !errors.As(err, &wsErr) || wsErr.Code != "rate_limited" {
+		t.Fatalf("frame past the burst = %v, want rate_limited", err)
+	}
+
+	// One frame a second refills
+	c.frames.last = c.frames.last.Add(-time.Second)
+	if err := c.allowFrame(); err != nil {
+		t.Errorf("frame after a second = %v, want it allowed", err)
+	}
+
+	t.Setenv("WS_FRAME_RATE", "0")
+	unlimited := &Client{hub: NewHub(nil)}
+	for i := 0; i < 100; i++ {
+		if err := unlimited.allowFrame(); err != nil {
+			t.Fatalf("WS_FRAME_RATE=0: frame %d = %v", i, err)
+		}
+	}
+}
+
+func TestJoinReplayFitsSendQueue(t *testing.T) {
+	t.Setenv("WS_HISTORY_SIZE", "1000")
+	t.Setenv("WS_HISTORY_FILE", "")
+	history, err := NewHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer history.Close()
+
+	const kept = 2 * wsSendBuffer
+	frames := make(map[string][][]byte)
+	for _, room := range []string{"a", "b"} {
+		for i := 0; i < kept; i++ {
+			frame, err := newFrame(msgMessage, room+strconv.Itoa(i), room, ChatMessage{Text: "hi"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			history.Record(room, frame)
+			frames[room] = append(frames[room], frame)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	hub := NewHub(nil)
+	hub.History = history
+	go hub.Run(ctx)
+
+	client := &Client{hub: hub, send: make(chan []byte, wsSendBuffer), rooms: make(map[string]bool)}
+	if !hub.Register(client) {
+		t.Fatal("Register failed")
+	}
+
+	// Only the newest frames that leave the headroom free are replayed
+	if _, err := hub.Join(client, "a", HistoryQuery{}); err != nil {
+		t.Fatalf("Join: %v", err)
+	}
+	want := wsSendBuffer - wsReplayHeadroom
+	if got := len(client.send); got != want {
+		t.Fatalf("replayed %d frames, want %d", got, want)
+	}
+	for i, frame := range frames["a"][kept-want:] {
+		if got := <-client.send; string(got) != string(frame) {
+			t.Fatalf("replayed frame %d = %s, want %s", i, got, frame)
+		}
+	}
+
+	// A queue that is already as full as a replay may make it gets none
+	for i := 0; i < want; i++ {
+		client.send <- []byte("live")
+	}
+	if _, err := hub.Join(client, "b", HistoryQuery{}); err != nil {
+		t.Fatalf("Join: %v", err)
+	}
+	if !hub.IsMember(client, "b") {
+		t.Fatal("client was evicted by the replay")
+	}
+	if got := len(client.send); got != want {
+		t.Errorf("%d frames queued after the second join, want %d", got, want)
+	}
+}
@@ -0,0 +1,89 @@
+// Package broadcast carries WebSocket room frames between hub instances, so
+// clients connected to different instances behind a load balancer still
+// share rooms.
+package broadcast
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrQueueFull is returned by Publish when frames are produced faster than
+// the backend can send them.
+var ErrQueueFull = errors.New("broadcast: queue full")
+
+// Handler delivers a frame to the local members of room.
+type Handler func(room string, data []byte)
+
+// Broadcaster publishes room frames to every hub instance.
+type Broadcaster interface {
+	// Publish sends data for room to the handler of every instance, this
+	// one included.
+	Publish(room string, data []byte) error
+	// Subscribe sets the handler for frames published by any instance and
+	// receives them until ctx is cancelled. Call it once, before Publish.
+	Subscribe(ctx context.Context, handler Handler) error
+}
+
+// Config selects and configures a backend.
+type Config struct {
+	// Backend is "memory" or "redis". Delivery to other instances is
+	// best-effort: the Redis backend drops frames it cannot publish in time,
+	// counting them in the broadcast_dropped expvar, instead of retrying.
+	Backend string
+	// RedisURL is redis://[user:password@]host:port[/db], or rediss:// for
+	// TLS.
+	RedisURL string
+	// Channel is the Redis pub/sub channel frames are sent on.
+	Channel string
+}
+
+// New creates the backend described by cfg.
+func New(cfg Config) (Broadcaster, error) {
+	switch cfg.Backend {
+	case "", "memory":
+		return NewMemory(), nil
+	case "redis":
+		b, err := NewRedis(cfg.RedisURL, cfg.Channel)
+		if err != nil {
+			return nil, err
+		}
+		return b, nil
+	default:
+		return nil, fmt.Errorf("broadcast: unknown backend %q", cfg.Backend)
+	}
+}
+
+// Memory delivers frames within this process only. It is the backend for a
+// single instance.
+type Memory struct {
+	mu      sync.RWMutex
+	handler Handler
+}
+
+// NewMemory creates an in-process broadcaster.
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+// Publish hands data straight to the handler.
+func (m *Memory) Publish(room string, data []byte) error {
+	m.mu.RLock()
+	handler := m.handler
+	m.mu.RUnlock()
+
+	if handler != nil {
+		handler(room, data)
+	}
+	return nil
+}
+
+// Subscribe sets the handler.
+func (m *Memory) Subscribe(ctx context.Context, handler Handler) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.handler = handler
+	return nil
+}
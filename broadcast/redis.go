@@ -0,0 +1,218 @@
+package broadcast
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"expvar"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// redisQueueSize is how many frames may wait to be published.
+	redisQueueSize = 1024
+	// redisRetryPeriod is how long to wait before reconnecting to Redis.
+	redisRetryPeriod = 2 * time.Second
+	// redisWriteTimeout bounds a single PUBLISH.
+	redisWriteTimeout = 5 * time.Second
+)
+
+// droppedFrames counts frames that never reached the other instances, by
+// reason: "queue_full", "disconnected" or "publish_failed".
+var droppedFrames = expvar.NewMap("broadcast_dropped")
+
+// redisFrame is a frame on the pub/sub channel. Origin identifies the
+// instance that published it.
+type redisFrame struct {
+	Origin string          `json:"origin"`
+	Room   string          `json:"room"`
+	Data   json.RawMessage `json:"data"`
+}
+
+// Redis publishes frames on a Redis pub/sub channel shared by every
+// instance. A frame is delivered locally as soon as it is published and
+// skipped when it comes back from Redis. Frames published while Redis is
+// unreachable only reach this instance, and are counted in droppedFrames.
+type Redis struct {
+	opts    redisOptions
+	channel string
+	origin  string
+	queue   chan []byte
+
+	mu      sync.RWMutex
+	handler Handler
+}
+
+// NewRedis creates a broadcaster for the server at rawURL. It connects when
+// Subscribe is called.
+func NewRedis(rawURL, channel string) (*Redis, error) {
+	opts, err := parseRedisURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if channel == "" {
+		channel = "hub:ws"
+	}
+	origin := make([]byte, 8)
+	if _, err := rand.Read(origin); err != nil {
+		return nil, err
+	}
+	return &Redis{
+		opts:    opts,
+		channel: channel,
+		origin:  hex.EncodeToString(origin),
+		queue:   make(chan []byte, redisQueueSize),
+	}, nil
+}
+
+// Publish delivers data locally and queues it for the other instances.
+func (b *Redis) Publish(room string, data []byte) error {
+	b.mu.RLock()
+	handler := b.handler
+	b.mu.RUnlock()
+	if handler != nil {
+		handler(room, data)
+	}
+
+	payload, err := json.Marshal(redisFrame{Origin: b.origin, Room: room, Data: data})
+	if err != nil {
+		return err
+	}
+	select {
+	case b.queue <- payload:
+		return nil
+	default:
+		droppedFrames.Add("queue_full", 1)
+		return ErrQueueFull
+	}
+}
+
+// Subscribe sets the handler and starts publishing and receiving until ctx
+// is cancelled.
+func (b *Redis) Subscribe(ctx context.Context, handler Handler) error {
+	b.mu.Lock()
+	b.handler = handler
+	b.mu.Unlock()
+
+	go b.publishLoop(ctx)
+	go b.receiveLoop(ctx)
+	return nil
+}
+
+// publishLoop sends queued frames over one connection, reconnecting after
+// failures. Frames queued while disconnected, including the one whose
+// connection attempt failed, are dropped and counted rather than delivered
+// late.
+func (b *Redis) publishLoop(ctx context.Context) {
+	var conn *respConn
+	var retryAt time.Time
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+
+	for {
+		var payload []byte
+		select {
+		case <-ctx.Done():
+			return
+		case payload = <-b.queue:
+		}
+
+		if conn == nil {
+			if time.Now().Before(retryAt) {
+				droppedFrames.Add("disconnected", 1)
+				continue
+			}
+			var err error
+			if conn, err = dialRedis(ctx, b.opts); err != nil {
+				log.Printf("⚠️ Broadcast: failed to connect to Redis at %s: %v", b.opts.addr, err)
+				droppedFrames.Add("disconnected", 1)
+				retryAt = time.Now().Add(redisRetryPeriod)
+				continue
+			}
+		}
+
+		conn.conn.SetDeadline(time.Now().Add(redisWriteTimeout))
+		if _, err := conn.do("PUBLISH", b.channel, string(payload)); err != nil {
+			log.Printf("⚠️ Broadcast: failed to publish to Redis: %v", err)
+			droppedFrames.Add("publish_failed", 1)
+			conn.Close()
+			conn = nil
+		}
+	}
+}
+
+// receiveLoop subscribes to the channel and delivers frames from other
+// instances, resubscribing whenever the connection drops.
+func (b *Redis) receiveLoop(ctx context.Context) {
+	for ctx.Err() == nil {
+		if err := b.receive(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("⚠️ Broadcast: Redis subscription failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(redisRetryPeriod):
+		}
+	}
+}
+
+func (b *Redis) receive(ctx context.Context) error {
+	conn, err := dialRedis(ctx, b.opts)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Unblock the read below when ctx ends
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+
+	if err := conn.write("SUBSCRIBE", b.channel); err != nil {
+		return err
+	}
+	for {
+		reply, err := conn.read()
+		if err != nil {
+			return err
+		}
+		if rerr, ok := reply.(redisError); ok {
+			return rerr
+		}
+
+		// Pushed messages are ["message", channel, payload]
+		items, ok := reply.([]interface{})
+		if !ok || len(items) != 3 || items[0] != "message" {
+			continue
+		}
+		payload, _ := items[2].(string)
+
+		var frame redisFrame
+		if err := json.Unmarshal([]byte(payload), &frame); err != nil {
+			log.Printf("Broadcast: dropped malformed frame: %v", err)
+			continue
+		}
+		if frame.Origin == b.origin {
+			continue
+		}
+
+		b.mu.RLock()
+		handler := b.handler
+		b.mu.RUnlock()
+		if handler != nil {
+			handler(frame.Room, frame.Data)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package broadcast
+
+import (
+	"bufio"
+	"context"
+	"expvar"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis is a Redis server supporting just enough of AUTH, SELECT,
+// PUBLISH and SUBSCRIBE for the broadcaster.
+type fakeRedis struct {
+	listener net.Listener
+	password string
+
+	mu          sync.Mutex
+	subscribers map[string][]*respConn
+}
+
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen on loopback: %v", err)
+	}
+	f := &fakeRedis{listener: listener, password: password, subscribers: make(map[string][]*respConn)}
+	t.Cleanup(func() { listener.Close() })
+	go f.serve()
+	return f
+}
+
+func (f *fakeRedis) url() string {
+	return "redis://:" + f.password + "@" + f.listener.Addr().String() + "/1"
+}
+
+func (f *fakeRedis) serve() {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		go f.handle(&respConn{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)})
+	}
+}
+
+func (f *fakeRedis) handle(c *respConn) {
+	defer c.Close()
+	authed := f.password == ""
+	for {
+		reply, err := c.read()
+		if err != nil {
+			return
+		}
+		items, _ := reply.([]interface{})
+		var args []string
+		for _, item := range items {
+			s, _ := item.(string)
+			args = append(args, s)
+		}
+		if len(args) == 0 {
+			return
+		}
+
+		f.mu.Lock()
+		switch cmd := strings.ToUpper(args[0]); {
+		case cmd == "AUTH":
+			if args[len(args)-1] != f.password {
+				f.reply(c, "-WRONGPASS invalid username-password pair")
+				break
+			}
+			authed = true
+			f.reply(c, "+OK")
+		case !authed:
+			f.reply(c, "-NOAUTH Authentication required.")
+		case cmd == "SELECT":
+			f.reply(c, "+OK")
+		case cmd == "SUBSCRIBE":
+			f.subscribers[args[1]] = append(f.subscribers[args[1]], c)
+			c.write("subscribe", args[1], "1")
+		case cmd == "PUBLISH":
+			for _, sub := range f.subscribers[args[1]] {
+				sub.write("message", args[1], args[2])
+			}
+			f.reply(c, ":"+strconv.Itoa(len(f.subscribers[args[1]])))
+		default:
+			f.reply(c, "-ERR unknown command")
+		}
+		f.mu.Unlock()
+	}
+}
+
+func (f *fakeRedis) reply(c *respConn, line string) {
+	c.w.WriteString(line + "\r\n")
+	c.w.Flush()
+}
+
+// waitSubscribers waits until n connections subscribed to channel.
+func (f *fakeRedis) waitSubscribers(t *testing.T, channel string, n int) {
+	t.Helper()
+	var got int
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); {
+		f.mu.Lock()
+		got = len(f.subscribers[channel])
+		f.mu.Unlock()
+		if got >= n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("%d subscribers to %s, want %d", got, channel, n)
+}
+
+// frameRecorder collects the frames a handler receives.
+type frameRecorder struct {
+	mu     sync.Mutex
+	frames []string
+	got    chan struct{}
+}
+
+func newFrameRecorder() *frameRecorder {
+	return &frameRecorder{got: make(chan struct{}, 16)}
+}
+
+func (r *frameRecorder) handle(room string, data []byte) {
+	r.mu.Lock()
+	r.frames = append(r.frames, room+" "+string(data))
+	r.mu.Unlock()
+	r.got <- struct{}{}
+}
+
+func (r *frameRecorder) wait(t *testing.T) {
+	t.Helper()
+	select {
+	case <-r.got:
+	case <-time.After(2 * time.Second):
+		t.Fatal("no frame delivered")
+	}
+}
+
+func (r *frameRecorder) list() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.frames...)
+}
+
+func TestRedisDeliversAcrossInstances(t *testing.T) {
+	server := newFakeRedis(t, "secret")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	a, err := NewRedis(server.url(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewRedis(server.url(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotA, gotB := newFrameRecorder(), newFrameRecorder()
+	a.Subscribe(ctx, gotA.handle)
+	b.Subscribe(ctx, gotB.handle)
+	server.waitSubscribers(t, "hub:ws", 2)
+
+	if err := a.Publish("room", []byte(`{"type":"message"}`)); err != nil {
+		t.Fatal(err)
+	}
+	gotA.wait(t)
+	gotB.wait(t)
+
+	// Publish a second frame so a's echo of the first, if any, has arrived
+	if err := b.Publish("other", []byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+	gotA.wait(t)
+	gotB.wait(t)
+
+	want := []string{`room {"type":"message"}`, `other {}`}
+	for name, got := range map[string][]string{"a": gotA.list(), "b": gotB.list()} {
+		if strings.Join(got, "|") != strings.Join(want, "|") {
+			t.Errorf("%s received %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestRedisRejectedAuth(t *testing.T) {
+	server := newFakeRedis(t, "secret")
+	opts, err := parseRedisURL("redis://:wrong@" + server.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dialRedis(context.Background(), opts); err == nil || !strings.Contains(err.Error(), "WRONGPASS") {
+		t.Errorf("dialRedis with a wrong password = %v, want WRONGPASS", err)
+	}
+}
+
+func TestRedisCountsDroppedFrames(t *testing.T) {
+	// Nothing listens on a closed listener's address
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen on loopback: %v", err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	b, err := NewRedis("redis://"+addr, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	b.Subscribe(ctx, func(string, []byte) {})
+
+	dropped := func() int64 {
+		if v, ok := droppedFrames.Get("disconnected").(*expvar.Int); ok {
+			return v.Value()
+		}
+		return 0
+	}
+	before := dropped()
+	// The first frame fails to connect, the second arrives while waiting to
+	// retry
+	for i := 0; i < 2; i++ {
+		if err := b.Publish("room", []byte(`{}`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for deadline := time.Now().Add(2 * time.Second); dropped()-before < 2; {
+		if time.Now().After(deadline) {
+			t.Fatalf("dropped %d frames, want 2", dropped()-before)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMemoryDeliversToHandler(t *testing.T) {
+	m := NewMemory()
+	if err := m.Publish("room", []byte("before")); err != nil {
+		t.Errorf("Publish before Subscribe = %v", err)
+	}
+	got := newFrameRecorder()
+	m.Subscribe(context.Background(), got.handle)
+	m.Publish("room", []byte("hello"))
+	if frames := got.list(); len(frames) != 1 || frames[0] != "room hello" {
+		t.Errorf("frames = %q, want [room hello]", frames)
+	}
+}
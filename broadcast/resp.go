@@ -0,0 +1,203 @@
+package broadcast
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// redisDialTimeout bounds connecting and authenticating to Redis.
+const redisDialTimeout = 5 * time.Second
+
+// redisError is an error reply from the server.
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// redisOptions are the connection settings parsed from a Redis URL.
+type redisOptions struct {
+	addr     string
+	username string
+	password string
+	db       int
+	tls      bool
+}
+
+// parseRedisURL parses redis://[user:password@]host[:port][/db]. rediss://
+// connects over TLS.
+func parseRedisURL(raw string) (redisOptions, error) {
+	var opts redisOptions
+	u, err := url.Parse(raw)
+	if err != nil {
+		return opts, fmt.Errorf("broadcast: invalid Redis URL: %w", err)
+	}
+	switch u.Scheme {
+	case "redis":
+	case "rediss":
+		opts.tls = true
+	default:
+		return opts, fmt.Errorf("broadcast: Redis URL must use redis:// or rediss://, got %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return opts, errors.New("broadcast: Redis URL has no host")
+	}
+
+	opts.addr = u.Host
+	if u.Port() == "" {
+		opts.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		opts.username = u.User.Username()
+		opts.password, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if opts.db, err = strconv.Atoi(db); err != nil {
+			return opts, fmt.Errorf("broadcast: invalid Redis database %q", db)
+		}
+	}
+	return opts, nil
+}
+
+// respConn is a connection speaking the Redis protocol (RESP2). It is not
+// safe for concurrent use.
+type respConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+}
+
+// dialRedis connects, authenticates and selects the database.
+func dialRedis(ctx context.Context, opts redisOptions) (*respConn, error) {
+	ctx, cancel := context.WithTimeout(ctx, redisDialTimeout)
+	defer cancel()
+
+	dialer := &net.Dialer{KeepAlive: 30 * time.Second}
+	var conn net.Conn
+	var err error
+	if opts.tls {
+		host, _, _ := net.SplitHostPort(opts.addr)
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: host}}
+		conn, err = tlsDialer.DialContext(ctx, "tcp", opts.addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", opts.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := &respConn{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	if opts.password != "" {
+		args := []string{"AUTH", opts.password}
+		if opts.username != "" {
+			args = []string{"AUTH", opts.username, opts.password}
+		}
+		if _, err := c.do(args...); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if opts.db != 0 {
+		if _, err := c.do("SELECT", strconv.Itoa(opts.db)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	conn.SetDeadline(time.Time{})
+	return c, nil
+}
+
+// do sends a command and reads its reply. Error replies are returned as
+// errors.
+func (c *respConn) do(args ...string) (interface{}, error) {
+	if err := c.write(args...); err != nil {
+		return nil, err
+	}
+	reply, err := c.read()
+	if err != nil {
+		return nil, err
+	}
+	if rerr, ok := reply.(redisError); ok {
+		return nil, rerr
+	}
+	return reply, nil
+}
+
+// write sends a command as an array of bulk strings.
+func (c *respConn) write(args ...string) error {
+	fmt.Fprintf(c.w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(c.w, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	return c.w.Flush()
+}
+
+// read reads one reply: a string for simple and bulk strings (nil for a
+// null bulk string), an int64, a redisError or a []interface{} of replies.
+func (c *respConn) read() (interface{}, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("redis: malformed reply")
+	}
+	kind, body := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return redisError(body), nil
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, errors.New("redis: malformed bulk length")
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, errors.New("redis: malformed array length")
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = c.read(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("redis: unknown reply type %q", kind)
+	}
+}
+
+// Close closes the connection.
+func (c *respConn) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,363 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LocalStore keeps objects as files under a directory. Contents live in
+// <dir>/objects/<key> and attributes in <dir>/meta/<key>.json. Presigned
+// URLs are HMAC-signed and served by ServeHTTP, which is meant to be mounted
+// at PublicURL.
+type LocalStore struct {
+	dir        string
+	publicURL  string
+	signingKey []byte
+	maxBytes   int64
+}
+
+// localMeta is the sidecar file stored next to each object.
+type localMeta struct {
+	ContentType string            `json:"contentType,omitempty"`
+	ETag        string            `json:"etag"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+// NewLocal creates a store rooted at cfg.Dir. Without cfg.SigningKey a random
+// key is used, so presigned URLs do not survive restarts.
+func NewLocal(cfg Config) (*LocalStore, error) {
+	if cfg.Dir == "" {
+		return nil, errors.New("storage: local backend requires a directory")
+	}
+	for _, sub := range []string{"objects", "meta", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(cfg.Dir, sub), 0o755); err != nil {
+			return nil, fmt.Errorf("storage: %v", err)
+		}
+	}
+
+	key := cfg.SigningKey
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+	}
+	return &LocalStore{
+		dir:        cfg.Dir,
+		publicURL:  strings.TrimRight(cfg.PublicURL, "/"),
+		signingKey: key,
+		maxBytes:   cfg.MaxUploadBytes,
+	}, nil
+}
+
+func (s *LocalStore) objectPath(key string) string {
+	return filepath.Join(s.dir, "objects", filepath.FromSlash(key))
+}
+
+func (s *LocalStore) metaPath(key string) string {
+	return filepath.Join(s.dir, "meta", filepath.FromSlash(key)+".json")
+}
+
+func (s *LocalStore) Put(ctx context.Context, key string, body io.Reader, opts PutOptions) error {
+	if !ValidKey(key) {
+		return ErrInvalidKey
+	}
+
+	tmp, err := os.CreateTemp(filepath.Join(s.dir, "tmp"), "put-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := md5.New()
+	_, err = io.Copy(io.MultiWriter(tmp, hash), body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	meta, err := json.Marshal(localMeta{
+		ContentType: opts.ContentType,
+		ETag:        hex.EncodeToString(hash.Sum(nil)),
+		Metadata:    opts.Metadata,
+	})
+	if err != nil {
+		return err
+	}
+
+	path := s.objectPath(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.metaPath(key)), 0o755); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	return writeFileAtomic(s.metaPath(key), meta)
+}
+
+func (s *LocalStore) Get(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error) {
+	info, err := s.Head(ctx, key)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+	f, err := os.Open(s.objectPath(key))
+	if err != nil {
+		return nil, ObjectInfo{}, localError(err)
+	}
+	return f, info, nil
+}
+
+func (s *LocalStore) Head(ctx context.Context, key string) (ObjectInfo, error) {
+	if !ValidKey(key) {
+		return ObjectInfo{}, ErrInvalidKey
+	}
+	stat, err := os.Stat(s.objectPath(key))
+	if err != nil {
+		return ObjectInfo{}, localError(err)
+	}
+	if stat.IsDir() {
+		return ObjectInfo{}, ErrNotFound
+	}
+
+	var meta localMeta
+	if data, err := os.ReadFile(s.metaPath(key)); err == nil {
+		json.Unmarshal(data, &meta)
+	}
+	return ObjectInfo{
+		Key:          key,
+		Size:         stat.Size(),
+		ETag:         meta.ETag,
+		ContentType:  meta.ContentType,
+		LastModified: stat.ModTime(),
+		Metadata:     meta.Metadata,
+	}, nil
+}
+
+func (s *LocalStore) List(ctx context.Context, prefix string, fn func(ObjectInfo) bool) error {
+	root := filepath.Join(s.dir, "objects")
+	start := root
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		start = filepath.Join(root, filepath.FromSlash(prefix[:i]))
+	}
+
+	var objects []ObjectInfo
+	err := filepath.WalkDir(start, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return ctx.Err()
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := s.Head(ctx, key)
+		if err != nil {
+			return nil
+		}
+		info.ContentType, info.Metadata = "", nil
+		objects = append(objects, info)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Key < objects[j].Key
+	})
+	for _, info := range objects {
+		if !fn(info) {
+			break
+		}
+	}
+	return nil
+}
+
+func (s *LocalStore) Delete(ctx context.Context, key string) error {
+	if !ValidKey(key) {
+		return ErrInvalidKey
+	}
+	for _, path := range []string{s.objectPath(key), s.metaPath(key)} {
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *LocalStore) Presign(method, key, contentType string, ttl time.Duration) (string, error) {
+	if !validMethod(method) {
+		return "", fmt.Errorf("storage: cannot presign %s", method)
+	}
+	if !ValidKey(key) {
+		return "", ErrInvalidKey
+	}
+
+	expires := strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+	query := url.Values{}
+	query.Set("method", method)
+	query.Set("expires", expires)
+	if contentType != "" {
+		query.Set("contentType", contentType)
+	}
+	query.Set("signature", s.sign(method, key, contentType, expires))
+	return s.URL(key) + "?" + query.Encode(), nil
+}
+
+func (s *LocalStore) URL(key string) string {
+	return s.publicURL + "/" + (&url.URL{Path: key}).EscapedPath()
+}
+
+// sign returns the signature of a presigned request.
+func (s *LocalStore) sign(method, key, contentType, expires string) string {
+	mac := hmac.New(sha256.New, s.signingKey)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s", method, key, contentType, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verify checks a presigned request for method on key.
+func (s *LocalStore) verify(r *http.Request, method, key string) error {
+	query := r.URL.Query()
+	if query.Get("method") != method {
+		return errors.New("URL was not signed for this method")
+	}
+	expires := query.Get("expires")
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > unix {
+		return errors.New("URL has expired")
+	}
+	contentType := query.Get("contentType")
+	expected := s.sign(method, key, contentType, expires)
+	if !hmac.Equal([]byte(expected), []byte(query.Get("signature"))) {
+		return errors.New("invalid signature")
+	}
+	if contentType != "" && r.Header.Get("Content-Type") != contentType {
+		return errors.New("Content-Type does not match the signed URL")
+	}
+	return nil
+}
+
+// ServeHTTP serves objects with the key taken from the request path, which
+// must already have the mount prefix stripped. Reads are public, like a
+// public-read bucket; writes need a URL presigned for PUT. Objects are
+// served sandboxed and unsniffed, so an uploaded document cannot run
+// scripts on the API's origin.
+func (s *LocalStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	if !ValidKey(key) {
+		http.Error(w, "Invalid key", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		body, info, err := s.Get(r.Context(), key)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				http.NotFound(w, r)
+				return
+			}
+			http.Error(w, "Failed to read object", http.StatusInternalServerError)
+			return
+		}
+		defer body.Close()
+
+		if info.ContentType != "" {
+			w.Header().Set("Content-Type", info.ContentType)
+		}
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Content-Security-Policy", "sandbox")
+		if info.ETag != "" {
+			w.Header().Set("ETag", `"`+info.ETag+`"`)
+		}
+		http.ServeContent(w, r, "", info.LastModified, body.(io.ReadSeeker))
+
+	case http.MethodPut:
+		if err := s.verify(r, http.MethodPut, key); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		body := r.Body
+		if s.maxBytes > 0 {
+			body = http.MaxBytesReader(w, r.Body, s.maxBytes)
+		}
+		err := s.Put(r.Context(), key, body, PutOptions{ContentType: r.Header.Get("Content-Type")})
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, "Object too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, "Failed to store object", http.StatusInternalServerError)
+			return
+		}
+		info, err := s.Head(r.Context(), key)
+		if err == nil && info.ETag != "" {
+			w.Header().Set("ETag", `"`+info.ETag+`"`)
+		}
+		w.WriteHeader(http.StatusOK)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// localError maps missing files to ErrNotFound.
+func localError(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// writeFileAtomic writes data to path via a temporary file and rename.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,214 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestLocal(t *testing.T) *LocalStore {
+	t.Helper()
+	store, err := NewLocal(Config{Dir: t.TempDir(), PublicURL: "http://hub.test/blobs/", SigningKey: []byte("secret")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestLocalStorePutGetDelete(t *testing.T) {
+	ctx := context.Background()
+	store := newTestLocal(t)
+
+	opts := PutOptions{ContentType: "video/mp4", Metadata: map[string]string{"channel": "abc"}}
+	if err := store.Put(ctx, "channels/abc/clip.mp4", strings.NewReader("hello"), opts); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	body, info, err := store.Get(ctx, "channels/abc/clip.mp4")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	data, _ := io.ReadAll(body)
+	body.Close()
+	if string(data) != "hello" {
+		t.Errorf("body = %q, want hello", data)
+	}
+	if info.Size != 5 || info.ContentType != "video/mp4" || info.Metadata["channel"] != "abc" {
+		t.Errorf("info = %+v", info)
+	}
+	// The ETag is the MD5 of the contents, like S3's for single-part uploads
+	if info.ETag != "5d41402abc4b2a76b9719d911017c592" {
+		t.Errorf("ETag = %q", info.ETag)
+	}
+
+	if err := store.Delete(ctx, "channels/abc/clip.mp4"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := store.Head(ctx, "channels/abc/clip.mp4"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Head after Delete = %v, want ErrNotFound", err)
+	}
+	if err := store.Delete(ctx, "channels/abc/clip.mp4"); err != nil {
+		t.Errorf("Delete of a missing key = %v, want nil", err)
+	}
+}
+
+func TestLocalStoreRejectsInvalidKeys(t *testing.T) {
+	ctx := context.Background()
+	store := newTestLocal(t)
+	for _, key := range []string{"", "../escape", "a/../../b", "/abs", "a//b", "a\\b"} {
+		if err := store.Put(ctx, key, strings.NewReader("x"), PutOptions{}); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Put(%q) = %v, want ErrInvalidKey", key, err)
+		}
+		if _, err := store.Head(ctx, key); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Head(%q) = %v, want ErrInvalidKey", key, err)
+		}
+	}
+}
+
+func TestLocalStoreList(t *testing.T) {
+	ctx := context.Background()
+	store := newTestLocal(t)
+	for _, key := range []string{"b/2", "a/1", "a/2", "ab/3", "c"} {
+		if err := store.Put(ctx, key, strings.NewReader(key), PutOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	list := func(prefix string, max int) []string {
+		var keys []string
+		err := store.List(ctx, prefix, func(info ObjectInfo) bool {
+			keys = append(keys, info.Key)
+			return len(keys) < max
+		})
+		if err != nil {
+			t.Fatalf("List(%q): %v", prefix, err)
+		}
+		return keys
+	}
+
+	if got := strings.Join(list("", 100), ","); got != "a/1,a/2,ab/3,b/2,c" {
+		t.Errorf("List all = %s", got)
+	}
+	if got := strings.Join(list("a/", 100), ","); got != "a/1,a/2" {
+		t.Errorf("List a/ = %s", got)
+	}
+	if got := strings.Join(list("a", 100), ","); got != "a/1,a/2,ab/3" {
+		t.Errorf("List a = %s", got)
+	}
+	if got := strings.Join(list("", 2), ","); got != "a/1,a/2" {
+		t.Errorf("List stopping early = %s", got)
+	}
+	if got := list("missing/", 100); len(got) != 0 {
+		t.Errorf("List missing/ = %v", got)
+	}
+}
+
+func TestLocalStorePresignedPut(t *testing.T) {
+	store := newTestLocal(t)
+	server := httptest.NewServer(http.StripPrefix("/blobs", store))
+	defer server.Close()
+
+	signed, err := store.Presign(http.MethodPut, "uploads/x.mp4", "video/mp4", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(signed, "http://hub.test/blobs/uploads/x.mp4?") {
+		t.Fatalf("presigned URL = %q", signed)
+	}
+	u, _ := url.Parse(signed)
+	target := server.URL + u.RequestURI()
+
+	put := func(target, contentType string) int {
+		req, _ := http.NewRequest(http.MethodPut, target, strings.NewReader("video"))
+		req.Header.Set("Content-Type", contentType)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := put(target, "text/html"); code != http.StatusForbidden {
+		t.Errorf("PUT with another Content-Type = %d, want 403", code)
+	}
+	if code := put(strings.Replace(target, "x.mp4", "y.mp4", 1), "video/mp4"); code != http.StatusForbidden {
+		t.Errorf("PUT to another key = %d, want 403", code)
+	}
+	if code := put(target, "video/mp4"); code != http.StatusOK {
+		t.Fatalf("presigned PUT = %d, want 200", code)
+	}
+
+	resp, err := http.Get(server.URL + "/blobs/uploads/x.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(data) != "video" {
+		t.Errorf("GET = %d %q, want 200 video", resp.StatusCode, data)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "video/mp4" {
+		t.Errorf("Content-Type = %q, want video/mp4", ct)
+	}
+	if resp.Header.Get("X-Content-Type-Options") != "nosniff" || resp.Header.Get("Content-Security-Policy") != "sandbox" {
+		t.Errorf("GET is served without nosniff and sandbox: %v", resp.Header)
+	}
+
+	expired, _ := store.Presign(http.MethodPut, "uploads/x.mp4", "video/mp4", -time.Minute)
+	u, _ = url.Parse(expired)
+	if code := put(server.URL+u.RequestURI(), "video/mp4"); code != http.StatusForbidden {
+		t.Errorf("expired PUT = %d, want 403", code)
+	}
+}
+
+func TestValidKey(t *testing.T) {
+	for key, want := range map[string]bool{
+		"a":                       true,
+		"channels/abc/x.mp4":      true,
+		"":                        false,
+		"..":                      false,
+		"a/./b":                   false,
+		"a/":                      false,
+		"a\x00b":                  false,
+		strings.Repeat("a", 1025): false,
+	} {
+		if got := ValidKey(key); got != want {
+			t.Errorf("ValidKey(%q) = %v, want %v", key, got, want)
+		}
+	}
+}
+
+func TestLocalStorePresignedPutSizeLimit(t *testing.T) {
+	store, err := NewLocal(Config{Dir: t.TempDir(), PublicURL: "http://hub.test/blobs", SigningKey: []byte("secret"), MaxUploadBytes: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := store.Presign(http.MethodPut, "uploads/x.mp4", "", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(signed)
+	target := strings.TrimPrefix(u.RequestURI(), "/blobs")
+
+	rec := httptest.NewRecorder()
+	store.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, target, strings.NewReader("too long")))
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized PUT = %d, want 413", rec.Code)
+	}
+	if _, err := store.Head(context.Background(), "uploads/x.mp4"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Head after oversized PUT = %v, want ErrNotFound", err)
+	}
+
+	rec = httptest.NewRecorder()
+	store.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, target, strings.NewReader("fits")))
+	if rec.Code != http.StatusOK {
+		t.Errorf("PUT within the limit = %d, want 200", rec.Code)
+	}
+}
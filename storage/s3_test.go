@@ -0,0 +1,177 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// fakeS3 implements the calls S3Store makes against an in-memory bucket.
+type fakeS3 struct {
+	s3iface.S3API
+
+	keys      []string
+	tags      map[string]map[string]string
+	completed *s3.CompleteMultipartUploadInput
+}
+
+func (f *fakeS3) ListObjectsV2PagesWithContext(ctx aws.Context, input *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool, opts ...request.Option) error {
+	// Two keys per page
+	var page []*s3.Object
+	for i, key := range f.keys {
+		if !strings.HasPrefix(key, aws.StringValue(input.Prefix)) {
+			continue
+		}
+		page = append(page, &s3.Object{Key: aws.String(key), ETag: aws.String(`"etag"`), Size: aws.Int64(int64(i))})
+		if len(page) == 2 {
+			if !fn(&s3.ListObjectsV2Output{Contents: page}, false) {
+				return nil
+			}
+			page = nil
+		}
+	}
+	fn(&s3.ListObjectsV2Output{Contents: page}, true)
+	return nil
+}
+
+func (f *fakeS3) HeadObjectWithContext(ctx aws.Context, input *s3.HeadObjectInput, opts ...request.Option) (*s3.HeadObjectOutput, error) {
+	for _, key := range f.keys {
+		if key == aws.StringValue(input.Key) {
+			return &s3.HeadObjectOutput{
+				ContentLength: aws.Int64(3),
+				ETag:          aws.String(`"etag"`),
+				Metadata:      map[string]*string{"Channel": aws.String("abc")},
+			}, nil
+		}
+	}
+	return nil, awserr.New("NotFound", "Not Found", nil)
+}
+
+func (f *fakeS3) GetObjectTaggingWithContext(ctx aws.Context, input *s3.GetObjectTaggingInput, opts ...request.Option) (*s3.GetObjectTaggingOutput, error) {
+	out := &s3.GetObjectTaggingOutput{}
+	for k, v := range f.tags[aws.StringValue(input.Key)] {
+		out.TagSet = append(out.TagSet, &s3.Tag{Key: aws.String(k), Value: aws.String(v)})
+	}
+	return out, nil
+}
+
+func (f *fakeS3) CompleteMultipartUploadWithContext(ctx aws.Context, input *s3.CompleteMultipartUploadInput, opts ...request.Option) (*s3.CompleteMultipartUploadOutput, error) {
+	f.completed = input
+	return &s3.CompleteMultipartUploadOutput{}, nil
+}
+
+func TestS3StoreList(t *testing.T) {
+	fake := &fakeS3{keys: []string{"a/1", "a/2", "a/3", "b/1", "b/2"}}
+	store := NewS3WithClient(fake, "bucket", "")
+
+	var keys []string
+	err := store.List(context.Background(), "a/", func(info ObjectInfo) bool {
+		if info.ETag != "etag" {
+			t.Errorf("ETag = %q, want it unquoted", info.ETag)
+		}
+		keys = append(keys, info.Key)
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(keys, ","); got != "a/1,a/2,a/3" {
+		t.Errorf("List = %s", got)
+	}
+
+	keys = nil
+	store.List(context.Background(), "", func(info ObjectInfo) bool {
+		keys = append(keys, info.Key)
+		return len(keys) < 3
+	})
+	if len(keys) != 3 {
+		t.Errorf("List stopping after 3 returned %d keys", len(keys))
+	}
+}
+
+func TestS3StoreHead(t *testing.T) {
+	store := NewS3WithClient(&fakeS3{keys: []string{"a"}}, "bucket", "https://cdn.example.com/")
+
+	info, err := store.Head(context.Background(), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != 3 || info.ETag != "etag" || info.Metadata["channel"] != "abc" {
+		t.Errorf("info = %+v", info)
+	}
+	if _, err := store.Head(context.Background(), "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Head(missing) = %v, want ErrNotFound", err)
+	}
+	if got := store.URL("a/b.mp4"); got != "https://cdn.example.com/a/b.mp4" {
+		t.Errorf("URL = %q", got)
+	}
+	if got := NewS3WithClient(&fakeS3{}, "bucket", "").URL("k"); got != "https://bucket.s3.amazonaws.com/k" {
+		t.Errorf("default URL = %q", got)
+	}
+}
+
+func TestS3StoreRejectsInvalidKeys(t *testing.T) {
+	// fakeS3 panics on calls it does not implement, so every check must
+	// happen before the request is sent
+	ctx := context.Background()
+	store := NewS3WithClient(&fakeS3{}, "bucket", "")
+	for _, key := range []string{"", "../escape", "a/../../b", "/abs", "a//b", "a\\b"} {
+		if err := store.Put(ctx, key, strings.NewReader("x"), PutOptions{}); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Put(%q) = %v, want ErrInvalidKey", key, err)
+		}
+		if _, _, err := store.Get(ctx, key); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Get(%q) = %v, want ErrInvalidKey", key, err)
+		}
+		if _, err := store.Head(ctx, key); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Head(%q) = %v, want ErrInvalidKey", key, err)
+		}
+		if err := store.Delete(ctx, key); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Delete(%q) = %v, want ErrInvalidKey", key, err)
+		}
+		if _, err := store.Presign(http.MethodPut, key, "", time.Minute); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Presign(%q) = %v, want ErrInvalidKey", key, err)
+		}
+	}
+}
+
+func TestS3StoreTags(t *testing.T) {
+	fake := &fakeS3{tags: map[string]map[string]string{"a": {"channel": "abc"}}}
+	store := NewS3WithClient(fake, "bucket", "")
+
+	tags, err := store.Tags(context.Background(), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tags["channel"] != "abc" {
+		t.Errorf("tags = %v", tags)
+	}
+}
+
+func TestS3StoreCompleteMultipartSortsParts(t *testing.T) {
+	fake := &fakeS3{}
+	store := NewS3WithClient(fake, "bucket", "")
+
+	parts := []CompletedPart{{PartNumber: 3, ETag: "c"}, {PartNumber: 1, ETag: "a"}, {PartNumber: 2, ETag: "b"}}
+	if err := store.CompleteMultipart(context.Background(), "k", "upload", parts); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, part := range fake.completed.MultipartUpload.Parts {
+		got = append(got, aws.StringValue(part.ETag))
+	}
+	if strings.Join(got, ",") != "a,b,c" {
+		t.Errorf("parts = %v, want a,b,c", got)
+	}
+	if parts[0].PartNumber != 3 {
+		t.Error("CompleteMultipart reordered the caller's slice")
+	}
+}
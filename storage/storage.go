@@ -0,0 +1,144 @@
+// Package storage abstracts where the hub keeps media files, so the API can
+// run against S3 in production and a local directory in development.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when an object does not exist.
+	ErrNotFound = errors.New("storage: object not found")
+	// ErrInvalidKey is returned for keys that are empty or escape the store.
+	ErrInvalidKey = errors.New("storage: invalid key")
+)
+
+// ObjectInfo describes a stored object. List only fills Key, Size, ETag and
+// LastModified; Head and Get fill everything.
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ETag         string
+	ContentType  string
+	LastModified time.Time
+	Metadata     map[string]string
+}
+
+// PutOptions are the optional attributes of an object written with Put.
+type PutOptions struct {
+	ContentType string
+	Metadata    map[string]string
+}
+
+// BlobStore is a flat key → blob store.
+type BlobStore interface {
+	// Put writes body to key, replacing any existing object.
+	Put(ctx context.Context, key string, body io.Reader, opts PutOptions) error
+	// Get opens key for reading. The caller must close the reader.
+	Get(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error)
+	// Head returns the attributes of key without its contents.
+	Head(ctx context.Context, key string) (ObjectInfo, error)
+	// List calls fn for every object whose key starts with prefix, in key
+	// order, until fn returns false.
+	List(ctx context.Context, prefix string, fn func(ObjectInfo) bool) error
+	// Delete removes key. Deleting a missing key is not an error.
+	Delete(ctx context.Context, key string) error
+	// Presign returns a URL that lets the holder perform method (GET or PUT)
+	// on key without other credentials until ttl passes. For PUT, a non-empty
+	// contentType must be sent as the Content-Type header.
+	Presign(method, key, contentType string, ttl time.Duration) (string, error)
+	// URL returns the public URL of key.
+	URL(key string) string
+}
+
+// CompletedPart is an uploaded part of a multipart upload.
+type CompletedPart struct {
+	PartNumber int64
+	ETag       string
+}
+
+// MultipartStore is implemented by stores that support uploading large
+// objects in parts directly from the client.
+type MultipartStore interface {
+	CreateMultipart(ctx context.Context, key, contentType string) (uploadID string, err error)
+	PresignPart(key, uploadID string, partNumber int64, ttl time.Duration) (string, error)
+	CompleteMultipart(ctx context.Context, key, uploadID string, parts []CompletedPart) error
+	AbortMultipart(ctx context.Context, key, uploadID string) error
+}
+
+// Tagger is implemented by stores that keep key/value tags separately from
+// object metadata.
+type Tagger interface {
+	Tags(ctx context.Context, key string) (map[string]string, error)
+}
+
+// Config selects and configures a backend.
+type Config struct {
+	// Backend is "s3" or "local".
+	Backend string
+
+	// Bucket, Region and Endpoint configure the S3 backend. Endpoint points
+	// it at an S3-compatible store such as MinIO or LocalStack.
+	Bucket   string
+	Region   string
+	Endpoint string
+
+	// Dir is where the local backend keeps its files.
+	Dir string
+	// SigningKey signs the local backend's presigned URLs.
+	SigningKey []byte
+
+	// PublicURL is the base URL objects are served from. For S3 it defaults
+	// to the bucket's URL; for the local backend it is where the store is
+	// mounted.
+	PublicURL string
+
+	// MaxUploadBytes caps the body of a presigned local upload; zero means
+	// no limit.
+	MaxUploadBytes int64
+}
+
+// New creates the backend described by cfg.
+func New(cfg Config) (BlobStore, error) {
+	switch cfg.Backend {
+	case "", "s3":
+		store, err := NewS3(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	case "local":
+		store, err := NewLocal(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	default:
+		return nil, fmt.Errorf("storage: unknown backend %q", cfg.Backend)
+	}
+}
+
+// ValidKey reports whether key is a relative, slash-separated path without
+// empty, "." or ".." segments.
+func ValidKey(key string) bool {
+	if key == "" || len(key) > 1024 || strings.ContainsAny(key, "\\\x00") {
+		return false
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// validMethod reports whether method can be presigned.
+func validMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodPut
+}